@@ -0,0 +1,288 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dolphindb/api-go/v3/model"
+)
+
+// ColumnType 描述 DolphinDB 中一列的类型，Decimal 类型需要额外的 scale
+type ColumnType struct {
+	Type  model.DataTypeByte
+	Scale int32
+}
+
+var decimalScaleRegexp = regexp.MustCompile(`^DECIMAL\d+\((\d+)\)`)
+
+// DolphinDB 的 duration 字面量，API 只支持单个字母的单位
+var durationRegexp = regexp.MustCompile(`^\d+[a-zA-Z]$`)
+
+// 类型名到类型的映射，只包含可以由 Go 的值构造的类型
+var columnTypeNames = map[string]model.DataTypeByte{
+	"BOOL":          model.DtBool,
+	"CHAR":          model.DtChar,
+	"SHORT":         model.DtShort,
+	"INT":           model.DtInt,
+	"LONG":          model.DtLong,
+	"FLOAT":         model.DtFloat,
+	"DOUBLE":        model.DtDouble,
+	"DATE":          model.DtDate,
+	"MONTH":         model.DtMonth,
+	"TIME":          model.DtTime,
+	"MINUTE":        model.DtMinute,
+	"SECOND":        model.DtSecond,
+	"DATETIME":      model.DtDatetime,
+	"TIMESTAMP":     model.DtTimestamp,
+	"NANOTIME":      model.DtNanoTime,
+	"NANOTIMESTAMP": model.DtNanoTimestamp,
+	"DATEHOUR":      model.DtDateHour,
+	"SYMBOL":        model.DtSymbol,
+	"STRING":        model.DtString,
+	"BLOB":          model.DtBlob,
+	"UUID":          model.DtUUID,
+	"IPADDR":        model.DtIP,
+	"INT128":        model.DtInt128,
+	"DURATION":      model.DtDuration,
+	"DECIMAL32":     model.DtDecimal32,
+	"DECIMAL64":     model.DtDecimal64,
+	"DECIMAL128":    model.DtDecimal128,
+}
+
+// ParseTypeName 解析 DolphinDB 的类型名，例如 INT、SYMBOL、DECIMAL64(2)，不区分大小写
+func ParseTypeName(name string) (ColumnType, error) {
+	upper := strings.ToUpper(strings.TrimSpace(name))
+	base := upper
+	if idx := strings.Index(upper, "("); idx >= 0 {
+		base = upper[:idx]
+	}
+	dt, ok := columnTypeNames[base]
+	if !ok {
+		return ColumnType{}, fmt.Errorf("unsupported data type %s", name)
+	}
+	return ParseColumnType(int32(dt), upper), nil
+}
+
+// ParseColumnType 根据 schema().colDefs 中的 typeInt 和 typeString 构造 ColumnType
+func ParseColumnType(typeInt int32, typeString string) ColumnType {
+	ct := ColumnType{Type: model.DataTypeByte(typeInt)}
+	if m := decimalScaleRegexp.FindStringSubmatch(strings.ToUpper(typeString)); m != nil {
+		scale, _ := strconv.Atoi(m[1])
+		ct.Scale = int32(scale)
+	}
+	return ct
+}
+
+// 时间字符串支持的格式，除了 RFC3339 之外还支持 DolphinDB 的时间字面量格式
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006.01.02 15:04:05.000000000",
+	"2006.01.02T15:04:05.000000000",
+	"2006.01.02 15:04:05.000",
+	"2006.01.02T15:04:05.000",
+	"2006.01.02 15:04:05",
+	"2006.01.02T15:04:05",
+	"2006.01.02",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"15:04:05.000",
+	"15:04:05",
+}
+
+// NewScalarFromValue 将 Go 的值（通常来自 JSON）转换为指定类型的 DolphinDB 标量
+func NewScalarFromValue(ct ColumnType, v interface{}) (*model.Scalar, error) {
+	dt, err := newDataTypeFromValue(ct, v)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewScalar(dt), nil
+}
+
+// NewVectorFromValues 将一组 Go 的值转换为指定类型的 DolphinDB 向量
+func NewVectorFromValues(ct ColumnType, values []interface{}) (*model.Vector, error) {
+	dts := make([]model.DataType, len(values))
+	for i, v := range values {
+		dt, err := newDataTypeFromValue(ct, v)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		dts[i] = dt
+	}
+	return model.NewVector(model.NewDataTypeList(ct.Type, dts)), nil
+}
+
+// NewTableFromRows 按照给定的列名和列类型把行数据构造成 DolphinDB 的表，行中缺少的列为空值
+func NewTableFromRows(names []string, types []ColumnType, rows []map[string]interface{}) (*model.Table, error) {
+	cols := make([]*model.Vector, len(names))
+	for i, name := range names {
+		values := make([]interface{}, len(rows))
+		for r, row := range rows {
+			values[r] = row[name]
+		}
+		vct, err := NewVectorFromValues(types[i], values)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		cols[i] = vct
+	}
+	return model.NewTable(names, cols), nil
+}
+
+// IsTemporal 判断类型是否是 DolphinDB 的时间类型
+func IsTemporal(ct ColumnType) bool {
+	switch ct.Type {
+	case model.DtDate, model.DtMonth, model.DtTime, model.DtMinute, model.DtSecond,
+		model.DtDatetime, model.DtTimestamp, model.DtNanoTime, model.DtNanoTimestamp, model.DtDateHour:
+		return true
+	}
+	return false
+}
+
+func newDataTypeFromValue(ct ColumnType, v interface{}) (model.DataType, error) {
+	if v == nil {
+		return model.NewDataType(ct.Type, nil)
+	}
+	arg, err := convertToDataTypeArg(ct, v)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewDataType(ct.Type, arg)
+}
+
+// convertToDataTypeArg 把 Go 的值转换为 model.NewDataType 对应类型需要的参数
+func convertToDataTypeArg(ct ColumnType, v interface{}) (interface{}, error) {
+	typeName := model.GetDataTypeString(ct.Type)
+	switch ct.Type {
+	case model.DtBool:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		}
+		f, err := toNumber(v)
+		if err != nil {
+			return nil, fmt.Errorf("expected %s, got %v", typeName, v)
+		}
+		return f != 0, nil
+	case model.DtChar:
+		f, err := toInteger(v, math.MinInt8, math.MaxInt8)
+		if err != nil {
+			return nil, err
+		}
+		return byte(int8(f)), nil
+	case model.DtShort:
+		f, err := toInteger(v, math.MinInt16, math.MaxInt16)
+		return int16(f), err
+	case model.DtInt:
+		f, err := toInteger(v, math.MinInt32, math.MaxInt32)
+		return int32(f), err
+	case model.DtLong:
+		f, err := toInteger(v, math.MinInt64, math.MaxInt64)
+		return f, err
+	case model.DtFloat:
+		f, err := toNumber(v)
+		return float32(f), err
+	case model.DtDouble:
+		return toNumber(v)
+	case model.DtDecimal32:
+		f, err := toNumber(v)
+		return &model.Decimal32{Scale: ct.Scale, Value: f}, err
+	case model.DtDecimal64:
+		f, err := toNumber(v)
+		return &model.Decimal64{Scale: ct.Scale, Value: f}, err
+	case model.DtDecimal128:
+		return &model.Decimal128{Scale: ct.Scale, Value: fmt.Sprintf("%v", v)}, nil
+	case model.DtString, model.DtSymbol, model.DtCode, model.DtFunction, model.DtHandle,
+		model.DtUUID, model.DtIP, model.DtInt128:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("expected %s, got %v", typeName, v)
+	case model.DtBlob:
+		if s, ok := v.(string); ok {
+			return []byte(s), nil
+		}
+		return nil, fmt.Errorf("expected %s, got %v", typeName, v)
+	case model.DtDate, model.DtMonth, model.DtTime, model.DtMinute, model.DtSecond,
+		model.DtDatetime, model.DtTimestamp, model.DtNanoTime, model.DtNanoTimestamp, model.DtDateHour:
+		return toTime(v)
+	case model.DtDuration:
+		if s, ok := v.(string); ok && durationRegexp.MatchString(s) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("expected a duration such as 1m, got %v", v)
+	}
+	return nil, fmt.Errorf("unsupported data type %s", typeName)
+}
+
+func toNumber(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case json.Number:
+		return n.Float64()
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("expected a number, got %v", v)
+}
+
+func toInteger(v interface{}, min int64, max int64) (int64, error) {
+	var i int64
+	switch n := v.(type) {
+	case json.Number:
+		parsed, err := n.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("expected an integer, got %v", v)
+		}
+		i = parsed
+	case int:
+		i = int64(n)
+	case int32:
+		i = int64(n)
+	case int64:
+		i = n
+	default:
+		f, err := toNumber(v)
+		if err != nil || f != math.Trunc(f) {
+			return 0, fmt.Errorf("expected an integer, got %v", v)
+		}
+		i = int64(f)
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("integer %d out of range", i)
+	}
+	return i, nil
+}
+
+// toTime 支持时间字符串和 Unix 毫秒时间戳，时间都按 UTC 处理，和查询结果的转换保持一致
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC(), nil
+	case string:
+		for _, layout := range timeLayouts {
+			if parsed, err := time.Parse(layout, t); err == nil {
+				return parsed.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time %s", t)
+	}
+	ms, err := toInteger(v, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a time string or unix milliseconds, got %v", v)
+	}
+	return time.UnixMilli(ms).UTC(), nil
+}
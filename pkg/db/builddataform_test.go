@@ -0,0 +1,43 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dolphindb/api-go/v3/model"
+)
+
+func TestNewTableFromRows(t *testing.T) {
+	names := []string{"time", "sym", "qty", "price"}
+	types := []ColumnType{
+		{Type: model.DtTimestamp},
+		{Type: model.DtSymbol},
+		{Type: model.DtInt},
+		ParseColumnType(int32(model.DtDecimal64), "DECIMAL64(2)"),
+	}
+	rows := []map[string]interface{}{
+		{"time": "2024.01.02 09:30:00.000", "sym": "AAPL", "qty": json.Number("100"), "price": json.Number("1.25")},
+		{"time": json.Number("1704187800000"), "sym": "MSFT"},
+	}
+
+	tb, err := NewTableFromRows(names, types, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tb.Rows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", tb.Rows())
+	}
+	if got := tb.GetColumnByName("qty").Get(0).String(); got != "100" {
+		t.Fatalf("unexpected qty %s", got)
+	}
+	if !tb.GetColumnByName("qty").IsNull(1) {
+		t.Fatal("missing columns must be null")
+	}
+	if types[3].Scale != 2 {
+		t.Fatalf("unexpected decimal scale %d", types[3].Scale)
+	}
+
+	if _, err := NewTableFromRows([]string{"qty"}, []ColumnType{{Type: model.DtInt}}, []map[string]interface{}{{"qty": json.Number("1.5")}}); err == nil {
+		t.Fatal("expected an error for a non-integer value")
+	}
+}
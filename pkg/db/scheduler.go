@@ -0,0 +1,278 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dolphindb/api-go/v3/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Priority 是查询的优先级，队列中优先级高的查询先执行
+type Priority int
+
+const (
+	PriorityExplore Priority = iota
+	PriorityDashboard
+	PriorityAlerting
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityAlerting:
+		return "alerting"
+	case PriorityDashboard:
+		return "dashboard"
+	}
+	return "explore"
+}
+
+// 默认的排队超时时间
+const DefaultQueueTimeout = 30 * time.Second
+
+// ErrQueueTimeout 表示查询在队列中等待的时间超过了排队超时时间
+var ErrQueueTimeout = errors.New("query waited too long in the datasource queue")
+
+var (
+	queueWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "dolphindb",
+		Name:      "query_queue_wait_seconds",
+		Help:      "Time queries spend waiting for a free slot in the datasource scheduler.",
+		Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10, 30},
+	}, []string{"datasource", "priority"})
+	queueRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dolphindb",
+		Name:      "query_queue_rejected_total",
+		Help:      "Queries rejected because they waited longer than the queue timeout.",
+	}, []string{"datasource", "priority"})
+	queueLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dolphindb",
+		Name:      "query_queue_length",
+		Help:      "Queries currently waiting in the datasource scheduler.",
+	}, []string{"datasource"})
+)
+
+func init() {
+	prometheus.MustRegister(queueWaitSeconds, queueRejected, queueLength)
+}
+
+// SchedulerConfig 是数据源的调度配置，MaxConcurrent 为 0 时不限制并发
+type SchedulerConfig struct {
+	MaxConcurrent int
+	QueueTimeout  time.Duration
+}
+
+// Scheduler 限制一个数据源同时执行的查询数
+// 没有空闲的位置时查询进入队列，先按优先级，同一优先级内在用户之间轮流，避免一个用户占满连接池
+type Scheduler struct {
+	name   string
+	config SchedulerConfig
+
+	mu      sync.Mutex
+	running int
+	// 每个优先级中按用户分开的等待队列，以及用户轮转的顺序
+	queues [PriorityAlerting + 1]map[string][]*waiter
+	order  [PriorityAlerting + 1][]string
+	queued int
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func NewScheduler(name string, config SchedulerConfig) *Scheduler {
+	if config.QueueTimeout <= 0 {
+		config.QueueTimeout = DefaultQueueTimeout
+	}
+	s := &Scheduler{name: name, config: config}
+	for i := range s.queues {
+		s.queues[i] = make(map[string][]*waiter)
+	}
+	return s
+}
+
+var (
+	schedulerMap     = make(map[string]*Scheduler)
+	schedulerMapLock sync.Mutex
+)
+
+// GetScheduler 返回数据源的调度器，配置变化时创建新的调度器，已经在执行的查询不受影响
+func GetScheduler(uuid string, config SchedulerConfig) *Scheduler {
+	schedulerMapLock.Lock()
+	defer schedulerMapLock.Unlock()
+	if config.QueueTimeout <= 0 {
+		config.QueueTimeout = DefaultQueueTimeout
+	}
+	if s, exists := schedulerMap[uuid]; exists && s.config == config {
+		return s
+	}
+	s := NewScheduler(uuid, config)
+	schedulerMap[uuid] = s
+	return s
+}
+
+// Acquire 等待一个执行的位置，成功时返回释放位置的函数
+// 超过排队超时时间返回 ErrQueueTimeout，ctx 取消时返回 ctx 的错误
+func (s *Scheduler) Acquire(ctx context.Context, user string, priority Priority) (func(), error) {
+	if s == nil || s.config.MaxConcurrent <= 0 {
+		return func() {}, nil
+	}
+	if priority < PriorityExplore || priority > PriorityAlerting {
+		priority = PriorityExplore
+	}
+	start := time.Now()
+	labels := prometheus.Labels{"datasource": s.name, "priority": priority.String()}
+
+	s.mu.Lock()
+	if s.running < s.config.MaxConcurrent && s.queued == 0 {
+		s.running++
+		s.mu.Unlock()
+		queueWaitSeconds.With(labels).Observe(0)
+		return s.release, nil
+	}
+	w := &waiter{ready: make(chan struct{})}
+	if _, ok := s.queues[priority][user]; !ok {
+		s.order[priority] = append(s.order[priority], user)
+	}
+	s.queues[priority][user] = append(s.queues[priority][user], w)
+	s.queued++
+	queueLength.WithLabelValues(s.name).Set(float64(s.queued))
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.config.QueueTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		queueWaitSeconds.With(labels).Observe(time.Since(start).Seconds())
+		return s.release, nil
+	case <-timer.C:
+		err = fmt.Errorf("%w: waited %s for one of %d slots", ErrQueueTimeout, s.config.QueueTimeout, s.config.MaxConcurrent)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	if w.granted {
+		// 超时的同时拿到了位置，直接使用
+		s.mu.Unlock()
+		queueWaitSeconds.With(labels).Observe(time.Since(start).Seconds())
+		return s.release, nil
+	}
+	s.remove(priority, user, w)
+	queueLength.WithLabelValues(s.name).Set(float64(s.queued))
+	s.mu.Unlock()
+	if errors.Is(err, ErrQueueTimeout) {
+		queueRejected.With(labels).Inc()
+	}
+	return nil, err
+}
+
+func (s *Scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	for s.running < s.config.MaxConcurrent {
+		w := s.next()
+		if w == nil {
+			break
+		}
+		w.granted = true
+		s.running++
+		close(w.ready)
+	}
+	queueLength.WithLabelValues(s.name).Set(float64(s.queued))
+}
+
+// next 取出下一个等待的查询，调用时需要持有锁
+func (s *Scheduler) next() *waiter {
+	for p := PriorityAlerting; p >= PriorityExplore; p-- {
+		if len(s.order[p]) == 0 {
+			continue
+		}
+		user := s.order[p][0]
+		waiters := s.queues[p][user]
+		w := waiters[0]
+		s.order[p] = s.order[p][1:]
+		if len(waiters) > 1 {
+			s.queues[p][user] = waiters[1:]
+			// 这个用户还有查询在等待，排到队尾
+			s.order[p] = append(s.order[p], user)
+		} else {
+			delete(s.queues[p], user)
+		}
+		s.queued--
+		return w
+	}
+	return nil
+}
+
+// remove 把超时或者取消的查询移出队列，调用时需要持有锁
+func (s *Scheduler) remove(priority Priority, user string, w *waiter) {
+	waiters := s.queues[priority][user]
+	for i, item := range waiters {
+		if item != w {
+			continue
+		}
+		waiters = append(waiters[:i:i], waiters[i+1:]...)
+		s.queued--
+		break
+	}
+	if len(waiters) > 0 {
+		s.queues[priority][user] = waiters
+		return
+	}
+	delete(s.queues[priority], user)
+	for i, u := range s.order[priority] {
+		if u == user {
+			s.order[priority] = append(s.order[priority][:i:i], s.order[priority][i+1:]...)
+			break
+		}
+	}
+}
+
+// ScheduledTask 是需要经过调度器执行的任务
+type ScheduledTask struct {
+	Task     *api.Task
+	User     string
+	Priority Priority
+}
+
+// RunScheduledTasks 通过调度器并发执行任务，返回和 tasks 一一对应的错误
+// 没有调度器或者不限制并发时和 RunPoolTasks 一样一次提交所有任务
+func RunScheduledTasks(ctx context.Context, s *Scheduler, tasks []ScheduledTask, uuid string, config DBConfig) []error {
+	errs := make([]error, len(tasks))
+	if s == nil || s.config.MaxConcurrent <= 0 {
+		batch := make([]*api.Task, len(tasks))
+		for i, t := range tasks {
+			batch[i] = t.Task
+		}
+		if err := RunPoolTasks(batch, uuid, config); err != nil {
+			for i := range errs {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+
+	var wg sync.WaitGroup
+	for i, t := range tasks {
+		wg.Add(1)
+		go func(i int, t ScheduledTask) {
+			defer wg.Done()
+			release, err := s.Acquire(ctx, t.User, t.Priority)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer release()
+			errs[i] = RunPoolTasks([]*api.Task{t.Task}, uuid, config)
+		}(i, t)
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedulerPriorityAndFairness(t *testing.T) {
+	s := NewScheduler("test", SchedulerConfig{MaxConcurrent: 1, QueueTimeout: time.Second})
+	release, err := s.Acquire(context.Background(), "alice", PriorityDashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 位置被占用时依次排队：alice 的两个仪表盘查询、bob 的仪表盘查询、Explore 查询和告警查询
+	order := make(chan string, 5)
+	queued := 0
+	enqueue := func(name string, user string, p Priority) {
+		go func() {
+			r, err := s.Acquire(context.Background(), user, p)
+			if err != nil {
+				order <- "error"
+				return
+			}
+			order <- name
+			r()
+		}()
+		// 等待进入队列，保证排队的顺序
+		queued++
+		for {
+			s.mu.Lock()
+			n := s.queued
+			s.mu.Unlock()
+			if n == queued {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	enqueue("alice-1", "alice", PriorityDashboard)
+	enqueue("alice-2", "alice", PriorityDashboard)
+	enqueue("bob-1", "bob", PriorityDashboard)
+	enqueue("explore", "carol", PriorityExplore)
+	enqueue("alert", "", PriorityAlerting)
+	release()
+
+	want := []string{"alert", "alice-1", "bob-1", "alice-2", "explore"}
+	for _, w := range want {
+		if got := <-order; got != w {
+			t.Fatalf("expected %s, got %s", w, got)
+		}
+	}
+}
+
+func TestSchedulerQueueTimeout(t *testing.T) {
+	s := NewScheduler("test", SchedulerConfig{MaxConcurrent: 1, QueueTimeout: 20 * time.Millisecond})
+	release, err := s.Acquire(context.Background(), "alice", PriorityDashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if _, err := s.Acquire(context.Background(), "bob", PriorityDashboard); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected queue timeout, got %v", err)
+	}
+	if s.queued != 0 || len(s.order[PriorityDashboard]) != 0 {
+		t.Fatalf("timed out query is still queued")
+	}
+}
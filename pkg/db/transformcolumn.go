@@ -0,0 +1,145 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 数组向量的类型是基础类型加 64
+const arrayVectorTypeOffset = 64
+
+func isArrayVectorType(dt model.DataTypeByte) bool {
+	return dt > arrayVectorTypeOffset && dt < 128
+}
+
+// TransformColumn 将表中的一列转换为 frame 的 field
+// 普通列转换为一个 field，数组向量列按 opts 渲染为 JSON 或者展开为多个 field
+func TransformColumn(name string, vector *model.Vector, opts TransformOptions) ([]*data.Field, error) {
+	dt := vector.GetDataType()
+	if !isArrayVectorType(dt) {
+		values, err := TransformVector(vector)
+		if err != nil {
+			return nil, err
+		}
+		return []*data.Field{data.NewField(name, nil, values)}, nil
+	}
+
+	// 数组向量的每一行是一个基础类型的切片
+	base := dt - arrayVectorTypeOffset
+	raw := vector.GetRawValue()
+	cells := make([]interface{}, len(raw))
+	for i, row := range raw {
+		rowValues, ok := row.([]interface{})
+		if !ok {
+			continue
+		}
+		cell, err := ConvertSlice(rowValues, base)
+		if err != nil {
+			return nil, err
+		}
+		cells[i] = cell
+	}
+	return arrayVectorFields(name, base, cells, opts)
+}
+
+// TransformStreamValue 将流数据消息中某一列的值转换为只有一行的 field
+// 和表的转换使用同一套逻辑，数组向量列在消息中是一个基础类型的向量
+func TransformStreamValue(name string, df model.DataForm, opts TransformOptions) ([]*data.Field, error) {
+	switch df.GetDataForm() {
+	case model.DfScalar:
+		sc := df.(*model.Scalar)
+		dt := sc.GetDataType()
+		if sc.IsNull() {
+			return []*data.Field{data.NewField(name, nil, nullSlice(dt, 1))}, nil
+		}
+		values, err := ConvertSlice([]interface{}{sc.Value()}, dt)
+		if err != nil {
+			return nil, err
+		}
+		return []*data.Field{data.NewField(name, nil, values)}, nil
+	case model.DfVector:
+		vct := df.(*model.Vector)
+		cell, err := TransformVector(vct)
+		if err != nil {
+			return nil, err
+		}
+		return arrayVectorFields(name, vct.GetDataType(), []interface{}{cell}, opts)
+	}
+	return nil, fmt.Errorf("unsupported dataform %s", df.GetDataFormString())
+}
+
+// nullSlice 创建 n 个空值的切片，元素类型为 dt 对应的指针类型
+func nullSlice(dt model.DataTypeByte, n int) interface{} {
+	return reflect.MakeSlice(reflect.SliceOf(reflect.PointerTo(GetTypeFromMap(dt))), n, n).Interface()
+}
+
+// arrayVectorFields 将数组向量的每一行（cells 中的元素为 ConvertSlice 的结果）转换为 field
+func arrayVectorFields(name string, base model.DataTypeByte, cells []interface{}, opts TransformOptions) ([]*data.Field, error) {
+	switch opts.ArrayVector {
+	case "", ArrayVectorJSON:
+		values := make([]*string, len(cells))
+		for i, cell := range cells {
+			if cell == nil {
+				continue
+			}
+			text, err := json.Marshal(cellValues(cell))
+			if err != nil {
+				return nil, err
+			}
+			str := string(text)
+			values[i] = &str
+		}
+		return []*data.Field{data.NewField(name, nil, values)}, nil
+	case ArrayVectorExpand:
+		// 展开的列数取最长的一行，短的行后面补空值
+		width := 0
+		for _, cell := range cells {
+			if cell != nil && reflect.ValueOf(cell).Len() > width {
+				width = reflect.ValueOf(cell).Len()
+			}
+		}
+		fields := make([]*data.Field, width)
+		for j := 0; j < width; j++ {
+			column := reflect.ValueOf(nullSlice(base, len(cells)))
+			for i, cell := range cells {
+				if cell == nil {
+					continue
+				}
+				cv := reflect.ValueOf(cell)
+				if j < cv.Len() {
+					column.Index(i).Set(cv.Index(j))
+				}
+			}
+			fields[j] = data.NewField(fmt.Sprintf("%s[%d]", name, j), nil, column.Interface())
+		}
+		return fields, nil
+	}
+	return nil, errors.New("unsupported array vector mode " + opts.ArrayVector)
+}
+
+// cellValues 将指针切片转换为可以序列化为 JSON 的值，空指针为 null
+func cellValues(cell interface{}) []interface{} {
+	cv := reflect.ValueOf(cell)
+	values := make([]interface{}, cv.Len())
+	for i := 0; i < cv.Len(); i++ {
+		elem := cv.Index(i)
+		if elem.Kind() == reflect.Pointer {
+			if elem.IsNil() {
+				continue
+			}
+			elem = elem.Elem()
+		}
+		if t, ok := elem.Interface().(time.Time); ok {
+			values[i] = t.Format(time.RFC3339Nano)
+			continue
+		}
+		values[i] = elem.Interface()
+	}
+	return values
+}
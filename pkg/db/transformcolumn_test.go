@@ -0,0 +1,64 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/dolphindb/api-go/v3/model"
+)
+
+func int32Cell(values ...int32) []*int32 {
+	cell := make([]*int32, len(values))
+	for i := range values {
+		cell[i] = &values[i]
+	}
+	return cell
+}
+
+func TestArrayVectorFields(t *testing.T) {
+	cells := []interface{}{int32Cell(1, 2, 3), int32Cell(4), nil}
+
+	fields, err := arrayVectorFields("levels", model.DtInt, cells, TransformOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 {
+		t.Fatalf("expected a single JSON field, got %d", len(fields))
+	}
+	if got := *fields[0].At(0).(*string); got != "[1,2,3]" {
+		t.Fatalf("unexpected JSON cell %s", got)
+	}
+	if got := fields[0].At(2).(*string); got != nil {
+		t.Fatalf("null rows must stay null, got %s", *got)
+	}
+
+	fields, err = arrayVectorFields("levels", model.DtInt, cells, TransformOptions{ArrayVector: ArrayVectorExpand})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 3 {
+		t.Fatalf("expected 3 expanded fields, got %d", len(fields))
+	}
+	if fields[2].Name != "levels[2]" {
+		t.Fatalf("unexpected field name %s", fields[2].Name)
+	}
+	if v := fields[0].At(1).(*int32); v == nil || *v != 4 {
+		t.Fatalf("unexpected expanded value %v", v)
+	}
+	if v := fields[1].At(1).(*int32); v != nil {
+		t.Fatalf("short rows must be padded with null, got %v", *v)
+	}
+}
+
+func TestTransformStreamValueScalar(t *testing.T) {
+	dt, err := model.NewDataType(model.DtDouble, float64(1.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := TransformStreamValue("price", model.NewScalar(dt), TransformOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := fields[0].At(0).(*float64); v == nil || *v != 1.5 {
+		t.Fatalf("unexpected value %v", v)
+	}
+}
@@ -11,26 +11,43 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
+// 数组向量列的展示方式
+const (
+	// 每行渲染为一个 JSON 数组字符串，默认
+	ArrayVectorJSON = "json"
+	// 按下标展开为 col[0]、col[1] 等多列
+	ArrayVectorExpand = "expand"
+)
+
+// TransformOptions 控制 dataform 转换为 frame 的方式
+type TransformOptions struct {
+	ArrayVector string
+}
+
 func TransformDataForm(dataform model.DataForm, framename string) (*data.Frame, error) {
+	return TransformDataFormWithOptions(dataform, framename, TransformOptions{})
+}
+
+func TransformDataFormWithOptions(dataform model.DataForm, framename string, opts TransformOptions) (*data.Frame, error) {
 
 	if dataform == nil {
 		frame := data.NewFrame(framename)
 		return frame, errors.New("get dataform error")
 	}
-		
+
 	// 获取 dataform 的类型
 	dataform_type := dataform.GetDataForm()
 
 	switch dataform_type {
 	case model.DfTable:
-		return transformTable(dataform.(*model.Table), framename), nil
+		return transformTable(dataform.(*model.Table), framename, opts), nil
 	}
 	// 现在只支持转换 Table
 	frame := data.NewFrame(framename)
 	return frame, errors.New("do not support this dataform. only supports table")
 }
 
-func transformTable(table *model.Table, framename string) *data.Frame {
+func transformTable(table *model.Table, framename string, opts TransformOptions) *data.Frame {
 	// columns count
 	columns := table.Columns()
 	columnnames := table.ColNames
@@ -42,18 +59,27 @@ func transformTable(table *model.Table, framename string) *data.Frame {
 
 	for i := 0; i < columns; i++ {
 		columnData := table.GetColumnByIndex(i)
-		columnValues, err := TransformVector(columnData)
+		fields, err := TransformColumn(columnnames[i], columnData, opts)
 		// 如果列转换失败，那就报错，然后不把这一列返回。正常的列依然添加到 Grafana 要返回的数据中，不受影响地被展示。
+		// 失败的列通过 notice 告诉用户，而不是悄悄丢掉
 		if err != nil {
 			log.DefaultLogger.Error("column transform error, %v", err)
+			frame.AppendNotices(unsupportedColumnNotice(columnnames[i], columnData.GetDataTypeString(), err))
 		} else {
-			frame.Fields = append(frame.Fields, data.NewField(columnnames[i], nil, columnValues))
+			frame.Fields = append(frame.Fields, fields...)
 		}
 	}
 
 	return frame
 }
 
+func unsupportedColumnNotice(name string, typeName string, err error) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("column %s of type %s is not supported and was skipped: %v", name, typeName, err),
+	}
+}
+
 func TransformDataFormToValues(df model.DataForm) ([]map[string]interface{}, error) {
 	// 获取 dataform 的类型
 	dataform_type := df.GetDataForm()
@@ -63,6 +89,8 @@ func TransformDataFormToValues(df model.DataForm) ([]map[string]interface{}, err
 	switch dataform_type {
 	case model.DfTable:
 		return transformTableToValues(df.(*model.Table))
+	case model.DfDictionary:
+		return transformDictionaryToValues(df.(*model.Dictionary))
 	case model.DfScalar:
 		sc := df.(*model.Scalar).Value()
 		dt := df.(*model.Scalar).GetDataType()
@@ -97,21 +125,95 @@ func TransformDataFormToValues(df model.DataForm) ([]map[string]interface{}, err
 	return []map[string]interface{}{}, fmt.Errorf("unable to transform dataform %s to values", dataform_type_str)
 }
 
+// 变量查询中有特殊含义的列
+const (
+	variableTextColumn  = "__text"
+	variableValueColumn = "__value"
+)
+
+// 标记选项是否可以展开的列，用于树形的元数据
+var variableExpandableColumns = []string{"__expandable", "expandable"}
+
 func transformTableToValues(tb *model.Table) ([]map[string]interface{}, error) {
-	// 超过一列就报错
-	if tb.Columns() > 1 {
-		return []map[string]interface{}{}, errors.New("table contains more than one column")
+	// 有 __text 或 __value 列时使用这两列，否则第一列为显示的文本，第二列为值
+	textColumn := tb.GetColumnByName(variableTextColumn)
+	valueColumn := tb.GetColumnByName(variableValueColumn)
+	if textColumn == nil && valueColumn == nil {
+		textColumn = tb.GetColumnByIndex(0)
+		if tb.Columns() > 1 {
+			valueColumn = tb.GetColumnByIndex(1)
+		}
+	}
+	if textColumn == nil {
+		textColumn = valueColumn
+	}
+	if valueColumn == nil {
+		valueColumn = textColumn
 	}
 
-	// 只转换第一列
-	columnData := tb.GetColumnByIndex(0)
-	columnValues, err := TransformVector(columnData)
+	texts, err := TransformVector(textColumn)
+	if err != nil {
+		return []map[string]interface{}{}, errors.New("unable to transform table to values")
+	}
+	values, err := TransformVector(valueColumn)
 	if err != nil {
 		return []map[string]interface{}{}, errors.New("unable to transform table to values")
 	}
-	values := columnValues
+	result, err := convertTextValues(texts, values)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range variableExpandableColumns {
+		if col := tb.GetColumnByName(name); col != nil {
+			for i := range result {
+				if !col.IsNull(i) {
+					result[i]["expandable"] = col.Get(i).String() == "true"
+				}
+			}
+			break
+		}
+	}
+	return result, nil
+}
 
-	return convertValues(values)
+// transformDictionaryToValues 把字典的键作为显示的文本，值作为变量的值
+func transformDictionaryToValues(dict *model.Dictionary) ([]map[string]interface{}, error) {
+	keys := dict.Keys
+	values := dict.Values
+	if keys == nil || values == nil {
+		return []map[string]interface{}{}, errors.New("unable to transform dictionary to values")
+	}
+	result := make([]map[string]interface{}, 0, keys.Rows())
+	for i := 0; i < keys.Rows(); i++ {
+		result = append(result, map[string]interface{}{
+			"text":  keys.Get(i).String(),
+			"value": values.Get(i).String(),
+		})
+	}
+	return result, nil
+}
+
+// convertTextValues 把文本列和值列（TransformVector 的结果）组合为变量的选项，空值的文本为空字符串
+func convertTextValues(texts interface{}, values interface{}) ([]map[string]interface{}, error) {
+	tv := reflect.ValueOf(texts)
+	vv := reflect.ValueOf(values)
+	if tv.Kind() != reflect.Slice || vv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected slices, got %T and %T", texts, values)
+	}
+
+	result := make([]map[string]interface{}, tv.Len())
+	for i := 0; i < tv.Len(); i++ {
+		text := ""
+		if elem := reflect.Indirect(tv.Index(i)); elem.IsValid() {
+			text = fmt.Sprintf("%v", elem)
+		}
+		result[i] = map[string]interface{}{
+			"text":  text,
+			"value": vv.Index(i).Interface(),
+		}
+	}
+	return result, nil
 }
 
 // func convert(values interface{}) []map[string]interface{} {
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/dolphindb/api-go/v3/model"
+)
+
+func TestTransformTableToValues(t *testing.T) {
+	names, err := NewVectorFromValues(ColumnType{Type: model.DtString}, []interface{}{"Shanghai", "Shenzhen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := NewVectorFromValues(ColumnType{Type: model.DtInt}, []interface{}{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expandable, err := NewVectorFromValues(ColumnType{Type: model.DtBool}, []interface{}{true, false})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := TransformDataFormToValues(model.NewTable([]string{"name", "id"}, []*model.Vector{names, ids}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[1]["text"] != "Shenzhen" || *values[1]["value"].(*int32) != 2 {
+		t.Fatalf("unexpected values %v", values[1])
+	}
+
+	values, err = TransformDataFormToValues(model.NewTable([]string{"__value", "__text", "expandable"}, []*model.Vector{ids, names, expandable}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0]["text"] != "Shanghai" || *values[0]["value"].(*int32) != 1 || values[0]["expandable"] != true {
+		t.Fatalf("unexpected values %v", values[0])
+	}
+}
@@ -0,0 +1,286 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// 查询中需要应用临时过滤条件的位置，展开为 where 条件，没有过滤条件时为 true
+var adhocFiltersMacro = regexp.MustCompile(`\$__adhocFilters\b`)
+
+var numberRegexp = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][-+]?\d+)?$`)
+
+// 标签值接口默认最多返回的值的个数
+const defaultTagValuesLimit = 1000
+
+type adhocFilterModel struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+	// =| 和 !=| 运算符的多个值
+	Values []string `json:"values,omitempty"`
+}
+
+// adhocTableModel 是数据源配置中提供标签键和标签值的表
+type adhocTableModel struct {
+	Database   string `json:"database,omitempty"`
+	Table      string `json:"table"`
+	TimeColumn string `json:"timeColumn,omitempty"`
+}
+
+type adhocSettingsModel struct {
+	AdhocTable *adhocTableModel `json:"adhocTable,omitempty"`
+}
+
+func parseAdhocSettings(jsonData json.RawMessage) (adhocSettingsModel, error) {
+	var settings adhocSettingsModel
+	err := json.Unmarshal(jsonData, &settings)
+	return settings, err
+}
+
+func (t *adhocTableModel) validate() error {
+	if t == nil {
+		return errors.New("no ad hoc filter table is configured for this datasource")
+	}
+	if !isValidIdentifier(t.Table) || (t.TimeColumn != "" && !isValidIdentifier(t.TimeColumn)) {
+		return errors.New("ad hoc filter table and time column must be valid identifiers")
+	}
+	return nil
+}
+
+// usesAdhocFilters 判断查询是否需要展开临时过滤条件
+func usesAdhocFilters(qm queryModel) bool {
+	return adhocFiltersMacro.MatchString(qm.QueryText)
+}
+
+// expandAdhocFilters 把 $__adhocFilters 展开为 where 条件，types 为已知的列类型，用来决定值是否加引号
+func expandAdhocFilters(script string, filters []adhocFilterModel, types map[string]db.ColumnType) (string, error) {
+	condition, err := adhocCondition(filters, types)
+	if err != nil {
+		return "", err
+	}
+	return adhocFiltersMacro.ReplaceAllLiteralString(script, condition), nil
+}
+
+func adhocCondition(filters []adhocFilterModel, types map[string]db.ColumnType) (string, error) {
+	if len(filters) == 0 {
+		return "true", nil
+	}
+	conditions := make([]string, 0, len(filters))
+	for _, f := range filters {
+		if !isValidIdentifier(f.Key) {
+			return "", fmt.Errorf("ad hoc filter key %s is not a valid column name", f.Key)
+		}
+		ct, known := types[f.Key]
+		literal := func(v string) (string, error) { return adhocLiteral(v, ct, known) }
+
+		switch f.Operator {
+		case "=", "!=", "<", ">", "<=", ">=":
+			v, err := literal(f.Value)
+			if err != nil {
+				return "", err
+			}
+			conditions = append(conditions, fmt.Sprintf("%s %s %s", f.Key, f.Operator, v))
+		case "=~", "!~":
+			cmp := ">="
+			if f.Operator == "!~" {
+				cmp = "<"
+			}
+			conditions = append(conditions, fmt.Sprintf("regexFind(string(%s), %s) %s 0", f.Key, strconv.Quote(f.Value), cmp))
+		case "=|", "!=|":
+			values := make([]string, len(f.Values))
+			for i, value := range f.Values {
+				v, err := literal(value)
+				if err != nil {
+					return "", err
+				}
+				values[i] = v
+			}
+			condition := fmt.Sprintf("%s in [%s]", f.Key, strings.Join(values, ", "))
+			if f.Operator == "!=|" {
+				condition = fmt.Sprintf("not(%s)", condition)
+			}
+			conditions = append(conditions, condition)
+		default:
+			return "", fmt.Errorf("unsupported ad hoc filter operator %s", f.Operator)
+		}
+	}
+	return strings.Join(conditions, " and "), nil
+}
+
+// adhocLiteral 把过滤值转换为脚本中的字面量
+// 已知列类型时按类型处理，不知道类型时看起来像数字的值不加引号
+func adhocLiteral(value string, ct db.ColumnType, known bool) (string, error) {
+	if !known {
+		if numberRegexp.MatchString(value) {
+			return value, nil
+		}
+		return strconv.Quote(value), nil
+	}
+	switch ct.Type {
+	case model.DtBool, model.DtChar, model.DtShort, model.DtInt, model.DtLong, model.DtFloat, model.DtDouble,
+		model.DtDecimal32, model.DtDecimal64, model.DtDecimal128:
+		if !numberRegexp.MatchString(value) && value != "true" && value != "false" {
+			return "", fmt.Errorf("ad hoc filter value %s is not a number", value)
+		}
+		return value, nil
+	case model.DtDate, model.DtMonth, model.DtTime, model.DtMinute, model.DtSecond,
+		model.DtDatetime, model.DtTimestamp, model.DtNanoTime, model.DtNanoTimestamp, model.DtDateHour:
+		// 时间列只接受 DolphinDB 的时间字面量
+		if _, err := parseDDBTime(value); err != nil {
+			return "", fmt.Errorf("ad hoc filter value %s is not a DolphinDB time literal", value)
+		}
+		return value, nil
+	}
+	return strconv.Quote(value), nil
+}
+
+// adhocColumnTypes 读取配置的表的列类型，没有配置或者读取失败时返回 nil
+func adhocColumnTypes(settings adhocSettingsModel, uid string, config db.DBConfig) map[string]db.ColumnType {
+	if settings.AdhocTable.validate() != nil {
+		return nil
+	}
+	types, err := tableColumnTypes(tableRef(settings.AdhocTable.Database, settings.AdhocTable.Table), uid, config)
+	if err != nil {
+		log.DefaultLogger.Warn("Unable to load ad hoc filter table schema", "error", err)
+		return nil
+	}
+	return types
+}
+
+// tableColumnTypes 读取表的列名到列类型的映射
+func tableColumnTypes(ref string, uid string, config db.DBConfig) (map[string]db.ColumnType, error) {
+	columns, types, err := loadTableSchema(ref, uid, config)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]db.ColumnType, len(columns))
+	for i, c := range columns {
+		result[c] = types[i]
+	}
+	return result, nil
+}
+
+type tagValuesRequestModel struct {
+	Key string `json:"key"`
+	// 时间范围，Unix 毫秒，为 0 时不限制
+	From  int64 `json:"from,omitempty"`
+	To    int64 `json:"to,omitempty"`
+	Limit int   `json:"limit,omitempty"`
+	// 时间范围按这个时区的墙上时间和表中的时间比较，为空时按 UTC
+	Timezone string `json:"timezone,omitempty"`
+}
+
+type metricFindValue struct {
+	Text string `json:"text"`
+}
+
+// handleTagKeys 返回配置的表的列名，作为临时过滤的标签键
+func (d *Datasource) handleTagKeys(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	settings, uid, config, err := loadAdhocSettings(req)
+	if err != nil {
+		return sendErrorResponse(sender, http.StatusBadRequest, err)
+	}
+	table := settings.AdhocTable
+	columns, _, err := loadTableSchema(tableRef(table.Database, table.Table), uid, config)
+	if err != nil {
+		return sendErrorResponse(sender, http.StatusBadRequest, err)
+	}
+	values := make([]metricFindValue, len(columns))
+	for i, c := range columns {
+		values[i] = metricFindValue{Text: c}
+	}
+	return sendJSONResponse(sender, values)
+}
+
+// handleTagValues 返回某一列在时间范围内的不同取值，作为临时过滤的标签值
+func (d *Datasource) handleTagValues(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	settings, uid, config, err := loadAdhocSettings(req)
+	if err != nil {
+		return sendErrorResponse(sender, http.StatusBadRequest, err)
+	}
+	var body tagValuesRequestModel
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		return sendErrorResponse(sender, http.StatusBadRequest, err)
+	}
+	if !isValidIdentifier(body.Key) {
+		return sendErrorResponse(sender, http.StatusBadRequest, fmt.Errorf("invalid tag key %s", body.Key))
+	}
+	script, err := tagValuesScript(settings.AdhocTable, body)
+	if err != nil {
+		return sendErrorResponse(sender, http.StatusBadRequest, err)
+	}
+
+	df, err := db.RunSimpleScript(script, uid, config)
+	if err != nil {
+		return sendErrorResponse(sender, http.StatusBadRequest, err)
+	}
+	tb, ok := df.(*model.Table)
+	if !ok {
+		return sendErrorResponse(sender, http.StatusBadRequest, fmt.Errorf("unexpected tag values result %s", df.GetDataFormString()))
+	}
+	values := make([]metricFindValue, 0, tb.Rows())
+	for i := 0; i < tb.Rows(); i++ {
+		if v := columnString(tb, body.Key, i); v != "" {
+			values = append(values, metricFindValue{Text: v})
+		}
+	}
+	return sendJSONResponse(sender, values)
+}
+
+// tagValuesScript 生成查询某一列不同取值的脚本，空值和返回的个数都在脚本中过滤，不把所有的取值都读到插件中
+func tagValuesScript(table *adhocTableModel, body tagValuesRequestModel) (string, error) {
+	limit := body.Limit
+	if limit <= 0 {
+		limit = defaultTagValuesLimit
+	}
+	conditions := []string{fmt.Sprintf("isValid(%s)", body.Key)}
+	if table.TimeColumn != "" && body.From > 0 && body.To > 0 {
+		loc, err := queryLocation(body.Timezone)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, fmt.Sprintf("%s between pair(%s, %s)", table.TimeColumn,
+			formatDDBTime(ddbTime(time.UnixMilli(body.From), loc)),
+			formatDDBTime(ddbTime(time.UnixMilli(body.To), loc))))
+	}
+	return fmt.Sprintf("select count(*) as cnt from %s where %s group by %s limit %d",
+		tableRef(table.Database, table.Table), strings.Join(conditions, " and "), body.Key, limit), nil
+}
+
+func loadAdhocSettings(req *backend.CallResourceRequest) (adhocSettingsModel, string, db.DBConfig, error) {
+	if req.PluginContext.DataSourceInstanceSettings == nil {
+		return adhocSettingsModel{}, "", db.DBConfig{}, errors.New("missing datasource instance settings")
+	}
+	settings, err := parseAdhocSettings(req.PluginContext.DataSourceInstanceSettings.JSONData)
+	if err != nil {
+		return settings, "", db.DBConfig{}, err
+	}
+	if err := settings.AdhocTable.validate(); err != nil {
+		return settings, "", db.DBConfig{}, err
+	}
+	uid, config, err := datasourceConfig(req.PluginContext)
+	return settings, uid, config, err
+}
+
+func sendJSONResponse(sender backend.CallResourceResponseSender, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return sendErrorResponse(sender, http.StatusInternalServerError, err)
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   body,
+	})
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/dolphindb/api-go/v3/model"
+)
+
+func TestExpandAdhocFilters(t *testing.T) {
+	types := map[string]db.ColumnType{
+		"sym":   {Type: model.DtSymbol},
+		"price": {Type: model.DtDouble},
+	}
+	filters := []adhocFilterModel{
+		{Key: "sym", Operator: "=", Value: "600000"},
+		{Key: "price", Operator: ">", Value: "10.5"},
+		{Key: "exchange", Operator: "=|", Values: []string{"SH", "SZ"}},
+	}
+	got, err := expandAdhocFilters("select * from t where $__adhocFilters", filters, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `select * from t where sym = "600000" and price > 10.5 and exchange in ["SH", "SZ"]`
+	if got != want {
+		t.Fatalf("unexpected script\n%s", got)
+	}
+
+	if got, _ := expandAdhocFilters("select * from t where $__adhocFilters", nil, nil); got != "select * from t where true" {
+		t.Fatalf("unexpected script without filters\n%s", got)
+	}
+	if _, err := expandAdhocFilters("$__adhocFilters", []adhocFilterModel{{Key: "price", Operator: "=", Value: "1 or 1=1"}}, types); err == nil {
+		t.Fatal("expected an error for a non-numeric value on a numeric column")
+	}
+	if _, err := expandAdhocFilters("$__adhocFilters", []adhocFilterModel{{Key: "a;drop", Operator: "=", Value: "1"}}, nil); err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+}
+
+func TestTagValuesScript(t *testing.T) {
+	table := &adhocTableModel{Database: "dfs://db", Table: "trades", TimeColumn: "ts"}
+	body := tagValuesRequestModel{
+		Key:      "sym",
+		From:     time.Date(2024, 1, 1, 16, 0, 0, 0, time.UTC).UnixMilli(),
+		To:       time.Date(2024, 1, 2, 16, 0, 0, 0, time.UTC).UnixMilli(),
+		Limit:    50,
+		Timezone: "Asia/Shanghai",
+	}
+	got, err := tagValuesScript(table, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 时间范围为 UTC+8 的墙上时间，空值和个数限制在脚本中处理
+	want := `select count(*) as cnt from loadTable("dfs://db", "trades") where isValid(sym) and ts between pair(2024.01.02T00:00:00.000, 2024.01.03T00:00:00.000) group by sym limit 50`
+	if got != want {
+		t.Fatalf("unexpected script\n%s", got)
+	}
+}
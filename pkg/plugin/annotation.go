@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 注释查询的 QueryType，由前端的 annotations.prepareQuery 设置
+const queryTypeAnnotation = "annotation"
+
+// annotationMappingModel 指定结果中哪一列作为注释的各个字段，为空时使用同名的列
+type annotationMappingModel struct {
+	Time    string `json:"time,omitempty"`
+	TimeEnd string `json:"timeEnd,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Tags    string `json:"tags,omitempty"`
+}
+
+func (m annotationMappingModel) column(field string) string {
+	mapped := map[string]string{
+		"time":    m.Time,
+		"timeEnd": m.TimeEnd,
+		"title":   m.Title,
+		"text":    m.Text,
+		"tags":    m.Tags,
+	}[field]
+	if mapped != "" {
+		return mapped
+	}
+	return field
+}
+
+// findField 按列名查找，找不到时忽略大小写再找一次
+func findField(frame *data.Frame, name string) *data.Field {
+	if f, _ := frame.FieldByName(name); f != nil {
+		return f
+	}
+	for _, f := range frame.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// toAnnotationFrame 把查询结果转换为 Grafana 注释需要的 time、timeEnd、title、text、tags 列
+func toAnnotationFrame(frame *data.Frame, mapping annotationMappingModel) (*data.Frame, error) {
+	timeField := findField(frame, mapping.column("time"))
+	if timeField == nil {
+		return nil, fmt.Errorf("annotation query must return a %s column", mapping.column("time"))
+	}
+
+	out := data.NewFrame(frame.Name)
+	out.Meta = frame.Meta
+	times, err := annotationTimes("time", timeField)
+	if err != nil {
+		return nil, err
+	}
+	out.Fields = append(out.Fields, times)
+
+	if f := findField(frame, mapping.column("timeEnd")); f != nil {
+		timeEnds, err := annotationTimes("timeEnd", f)
+		if err != nil {
+			return nil, err
+		}
+		out.Fields = append(out.Fields, timeEnds)
+	}
+	for _, name := range []string{"title", "text"} {
+		if f := findField(frame, mapping.column(name)); f != nil {
+			out.Fields = append(out.Fields, annotationStrings(name, f, formatFieldValue))
+		}
+	}
+	if f := findField(frame, mapping.column("tags")); f != nil {
+		out.Fields = append(out.Fields, annotationStrings("tags", f, formatTags))
+	}
+	return out, nil
+}
+
+// annotationTimes 转换注释的时间列，支持时间类型和 Unix 毫秒时间戳
+func annotationTimes(name string, f *data.Field) (*data.Field, error) {
+	values := make([]*time.Time, f.Len())
+	for i := 0; i < f.Len(); i++ {
+		v := f.At(i)
+		if t, ok := toTime(v); ok {
+			values[i] = &t
+			continue
+		}
+		if ms, ok := toFloat64(v); ok {
+			t := time.UnixMilli(int64(ms)).UTC()
+			values[i] = &t
+			continue
+		}
+		if !isNilValue(v) {
+			return nil, errors.New("annotation column " + f.Name + " must be a time or unix milliseconds")
+		}
+	}
+	return data.NewField(name, nil, values), nil
+}
+
+func annotationStrings(name string, f *data.Field, format func(interface{}) string) *data.Field {
+	values := make([]*string, f.Len())
+	for i := 0; i < f.Len(); i++ {
+		if isNilValue(f.At(i)) {
+			continue
+		}
+		s := format(f.At(i))
+		values[i] = &s
+	}
+	return data.NewField(name, nil, values)
+}
+
+// formatTags 把标签转换为 Grafana 使用的逗号分隔的字符串
+// 数组向量列渲染成了 JSON 数组，这里展开为逗号分隔
+func formatTags(v interface{}) string {
+	s := formatFieldValue(v)
+	var tags []interface{}
+	if strings.HasPrefix(s, "[") && json.Unmarshal([]byte(s), &tags) == nil {
+		parts := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			if tag != nil {
+				parts = append(parts, fmt.Sprint(tag))
+			}
+		}
+		return strings.Join(parts, ",")
+	}
+	return s
+}
+
+// isNilValue 判断 field 中的值是否为空值（空指针）
+func isNilValue(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return !rv.IsValid() || (rv.Kind() == reflect.Pointer && rv.IsNil())
+}
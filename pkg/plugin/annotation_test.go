@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestToAnnotationFrame(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	ms := ts.Add(time.Minute).UnixMilli()
+	reason := "trading halt"
+	tags := `["halt","AAPL"]`
+	frame := data.NewFrame("A",
+		data.NewField("haltTime", nil, []*time.Time{&ts}),
+		data.NewField("resumeTime", nil, []*int64{&ms}),
+		data.NewField("reason", nil, []*string{&reason}),
+		data.NewField("Tags", nil, []*string{&tags}),
+		data.NewField("ignored", nil, []*string{&reason}),
+	)
+
+	out, err := toAnnotationFrame(frame, annotationMappingModel{Time: "haltTime", TimeEnd: "resumeTime", Text: "reason"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Fields) != 4 {
+		t.Fatalf("expected time, timeEnd, text and tags, got %d fields", len(out.Fields))
+	}
+	timeEnd, _ := out.FieldByName("timeEnd")
+	if v := timeEnd.At(0).(*time.Time); v == nil || !v.Equal(ts.Add(time.Minute)) {
+		t.Fatalf("unexpected timeEnd %v", v)
+	}
+	tagsField, _ := out.FieldByName("tags")
+	if v := tagsField.At(0).(*string); *v != "halt,AAPL" {
+		t.Fatalf("unexpected tags %s", *v)
+	}
+
+	if _, err := toAnnotationFrame(frame, annotationMappingModel{}); err == nil {
+		t.Fatal("expected an error without a time column")
+	}
+}
@@ -0,0 +1,211 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// 可视化查询构建器的 QueryType，由后端把结构化的查询编译为 DolphinDB SQL
+const queryTypeBuilder = "builder"
+
+// 按时间分桶的间隔为 auto 时使用面板的 $__interval
+const builderIntervalAuto = "auto"
+
+var durationLiteralRegexp = regexp.MustCompile(`^\d+(ns|us|ms|s|m|H|d|w|M|y|B)$`)
+
+// 构建器支持的聚合函数
+var builderAggregations = []string{"avg", "sum", "min", "max", "count", "first", "last", "med", "std", "var"}
+
+type builderQueryModel struct {
+	// 分布式数据库路径，例如 dfs://StockDB，为空时 Table 为内存表或共享表
+	Database   string `json:"database,omitempty"`
+	Table      string `json:"table"`
+	TimeColumn string `json:"timeColumn,omitempty"`
+	// 直接选择的列，有聚合时必须出现在 GroupBy 中
+	Columns      []string                  `json:"columns,omitempty"`
+	Aggregations []builderAggregationModel `json:"aggregations,omitempty"`
+	GroupBy      []string                  `json:"groupBy,omitempty"`
+	// 过滤条件，和临时过滤条件的格式和运算符相同
+	Filters []adhocFilterModel `json:"filters,omitempty"`
+	// 按时间列分桶的间隔，例如 1m，auto 为面板的间隔，为空时不分桶
+	Interval string              `json:"interval,omitempty"`
+	OrderBy  []builderOrderModel `json:"orderBy,omitempty"`
+	Limit    int                 `json:"limit,omitempty"`
+
+	// 表的列类型，由 loadColumnTypes 读取，过滤值按列的类型生成字面量
+	columnTypes map[string]db.ColumnType
+}
+
+type builderAggregationModel struct {
+	Func string `json:"func"`
+	// count 可以为 *
+	Column string `json:"column"`
+	// 结果列名，为空时为 func_column
+	Alias string `json:"alias,omitempty"`
+}
+
+type builderOrderModel struct {
+	Column string `json:"column"`
+	// asc（默认）或 desc
+	Direction string `json:"direction,omitempty"`
+}
+
+// compileBuilderQuery 把结构化的查询编译为 DolphinDB SQL，时间过滤和间隔使用宏，由 expandMacros 展开
+func compileBuilderQuery(b builderQueryModel) (string, error) {
+	if b.Table == "" {
+		return "", errors.New("query builder requires a table")
+	}
+	if !isValidIdentifier(b.Table) {
+		return "", fmt.Errorf("invalid table name %s", b.Table)
+	}
+	if b.TimeColumn != "" && !isValidIdentifier(b.TimeColumn) {
+		return "", fmt.Errorf("invalid time column %s", b.TimeColumn)
+	}
+	for _, list := range [][]string{b.Columns, b.GroupBy} {
+		for _, c := range list {
+			if !isValidIdentifier(c) {
+				return "", fmt.Errorf("invalid column name %s", c)
+			}
+		}
+	}
+
+	var bucket string
+	switch {
+	case b.Interval == "":
+	case b.TimeColumn == "":
+		return "", errors.New("interval bucketing requires a time column")
+	case b.Interval == builderIntervalAuto:
+		bucket = fmt.Sprintf("bar(%s, $__interval) as %s", b.TimeColumn, b.TimeColumn)
+	case durationLiteralRegexp.MatchString(b.Interval):
+		bucket = fmt.Sprintf("bar(%s, %s) as %s", b.TimeColumn, b.Interval, b.TimeColumn)
+	default:
+		return "", fmt.Errorf("invalid interval %s", b.Interval)
+	}
+	grouped := bucket != "" || len(b.GroupBy) > 0 || len(b.Aggregations) > 0
+
+	// 分组查询的结果中已经包含分组列，这里不再重复选择
+	var selects []string
+	for _, c := range b.Columns {
+		if !grouped {
+			selects = append(selects, c)
+			continue
+		}
+		if !containsString(b.GroupBy, c) && !(bucket != "" && c == b.TimeColumn) {
+			return "", fmt.Errorf("column %s must be aggregated or appear in group by", c)
+		}
+	}
+	for _, a := range b.Aggregations {
+		expr, err := a.compile()
+		if err != nil {
+			return "", err
+		}
+		selects = append(selects, expr)
+	}
+	if len(selects) == 0 {
+		if grouped {
+			return "", errors.New("grouped queries require at least one aggregation")
+		}
+		selects = []string{"*"}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "select %s from %s", strings.Join(selects, ", "), tableRef(b.Database, b.Table))
+
+	var conditions []string
+	if b.TimeColumn != "" {
+		conditions = append(conditions, fmt.Sprintf("%s between $__timeFilter", b.TimeColumn))
+	}
+	if len(b.Filters) > 0 {
+		condition, err := adhocCondition(b.Filters, b.columnTypes)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) > 0 {
+		fmt.Fprintf(&sb, " where %s", strings.Join(conditions, " and "))
+	}
+
+	if grouped {
+		groups := append([]string{}, b.GroupBy...)
+		if bucket != "" {
+			groups = append(groups, bucket)
+		}
+		if len(groups) > 0 {
+			fmt.Fprintf(&sb, " group by %s", strings.Join(groups, ", "))
+		}
+	}
+
+	orders := make([]string, 0, len(b.OrderBy))
+	for _, o := range b.OrderBy {
+		if !isValidIdentifier(o.Column) {
+			return "", fmt.Errorf("invalid order by column %s", o.Column)
+		}
+		switch strings.ToLower(o.Direction) {
+		case "", "asc":
+			orders = append(orders, o.Column)
+		case "desc":
+			orders = append(orders, o.Column+" desc")
+		default:
+			return "", fmt.Errorf("invalid order direction %s", o.Direction)
+		}
+	}
+	// 没有指定排序时按时间排序，方便画时间序列
+	if len(orders) == 0 && b.TimeColumn != "" && (!grouped || bucket != "") {
+		orders = append(orders, b.TimeColumn)
+	}
+	if len(orders) > 0 {
+		fmt.Fprintf(&sb, " order by %s", strings.Join(orders, ", "))
+	}
+
+	if b.Limit < 0 {
+		return "", fmt.Errorf("invalid limit %d", b.Limit)
+	}
+	if b.Limit > 0 {
+		fmt.Fprintf(&sb, " limit %d", b.Limit)
+	}
+	return sb.String(), nil
+}
+
+// loadColumnTypes 读取表的列类型，只有过滤条件需要，读取失败时按值的形式生成字面量
+func (b *builderQueryModel) loadColumnTypes(uid string, config db.DBConfig) {
+	if len(b.Filters) == 0 || !isValidIdentifier(b.Table) {
+		return
+	}
+	types, err := tableColumnTypes(tableRef(b.Database, b.Table), uid, config)
+	if err != nil {
+		log.DefaultLogger.Warn("Unable to load query builder table schema", "table", b.Table, "error", err)
+		return
+	}
+	b.columnTypes = types
+}
+
+func (a builderAggregationModel) compile() (string, error) {
+	fn := strings.ToLower(a.Func)
+	if !containsString(builderAggregations, fn) {
+		return "", fmt.Errorf("unsupported aggregation %s", a.Func)
+	}
+	column := a.Column
+	if column == "*" && fn != "count" {
+		return "", fmt.Errorf("%s(*) is not supported", fn)
+	}
+	if column != "*" && !isValidIdentifier(column) {
+		return "", fmt.Errorf("invalid column name %s", column)
+	}
+	alias := a.Alias
+	if alias == "" {
+		alias = fn
+		if column != "*" {
+			alias = fn + "_" + column
+		}
+	}
+	if !isValidIdentifier(alias) {
+		return "", fmt.Errorf("invalid alias %s", alias)
+	}
+	return fmt.Sprintf("%s(%s) as %s", fn, column, alias), nil
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/dolphindb/api-go/v3/model"
+)
+
+func TestCompileBuilderQuery(t *testing.T) {
+	script, err := compileBuilderQuery(builderQueryModel{
+		Database:     "dfs://StockDB",
+		Table:        "trades",
+		TimeColumn:   "ts",
+		Columns:      []string{"sym"},
+		Aggregations: []builderAggregationModel{{Func: "AVG", Column: "price"}, {Func: "count", Column: "*", Alias: "n"}},
+		GroupBy:      []string{"sym"},
+		Filters:      []adhocFilterModel{{Key: "sym", Operator: "=|", Values: []string{"AAPL", "MSFT"}}, {Key: "qty", Operator: ">", Value: "100"}},
+		Interval:     builderIntervalAuto,
+		Limit:        500,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `select avg(price) as avg_price, count(*) as n from loadTable("dfs://StockDB", "trades") where ts between $__timeFilter and sym in ["AAPL", "MSFT"] and qty > 100 group by sym, bar(ts, $__interval) as ts order by ts limit 500`
+	if script != want {
+		t.Fatalf("unexpected script\n%s", script)
+	}
+
+	script, err = compileBuilderQuery(builderQueryModel{Table: "t", OrderBy: []builderOrderModel{{Column: "x", Direction: "desc"}}})
+	if err != nil || script != "select * from t order by x desc" {
+		t.Fatalf("unexpected script %s %v", script, err)
+	}
+
+	invalid := []builderQueryModel{
+		{Table: "t; drop"},
+		{Table: "t", Columns: []string{"a"}, GroupBy: []string{"b"}, Aggregations: []builderAggregationModel{{Func: "sum", Column: "c"}}},
+		{Table: "t", Aggregations: []builderAggregationModel{{Func: "exec", Column: "c"}}},
+		{Table: "t", Interval: "1m"},
+		{Table: "t", TimeColumn: "ts", Interval: "1m; x"},
+	}
+	for _, b := range invalid {
+		if _, err := compileBuilderQuery(b); err == nil {
+			t.Fatalf("expected error for %+v", b)
+		}
+	}
+}
+
+func TestCompileBuilderQueryColumnTypes(t *testing.T) {
+	b := builderQueryModel{
+		Table: "trades",
+		// 股票代码看起来像数字，但列是 SYMBOL 类型
+		Filters: []adhocFilterModel{{Key: "code", Operator: "=", Value: "600000"}, {Key: "qty", Operator: ">", Value: "100"}},
+		columnTypes: map[string]db.ColumnType{
+			"code": {Type: model.DtSymbol},
+			"qty":  {Type: model.DtInt},
+		},
+	}
+	script, err := compileBuilderQuery(b)
+	if err != nil || script != `select * from trades where code = "600000" and qty > 100` {
+		t.Fatalf("unexpected script %s %v", script, err)
+	}
+
+	b.Filters = []adhocFilterModel{{Key: "qty", Operator: ">", Value: "many"}}
+	if _, err := compileBuilderQuery(b); err == nil {
+		t.Fatal("expected an error for a non-numeric value on a numeric column")
+	}
+}
@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 缓存默认最多保存的查询结果数
+const defaultCacheMaxEntries = 1000
+
+// 查询结果在 frame 元数据中的缓存标记
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// 脚本中的时间字面量，前端展开 $__timeFilter 时使用空格，后端使用 T
+var timeLiteralRegexp = regexp.MustCompile(`\d{4}\.\d{2}\.\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d{1,9})?`)
+
+// cacheSettingsModel 是数据源配置中的结果缓存，cacheTTLSeconds 为 0 时默认不缓存
+type cacheSettingsModel struct {
+	CacheTTLSeconds int `json:"cacheTTLSeconds,omitempty"`
+	CacheMaxEntries int `json:"cacheMaxEntries,omitempty"`
+}
+
+func parseCacheSettings(jsonData json.RawMessage) (cacheSettingsModel, error) {
+	var settings cacheSettingsModel
+	err := json.Unmarshal(jsonData, &settings)
+	return settings, err
+}
+
+// cacheTTL 返回查询结果的缓存时间，查询中的设置优先，为 0 时不缓存
+func cacheTTL(settings cacheSettingsModel, qm queryModel) time.Duration {
+	seconds := settings.CacheTTLSeconds
+	if qm.CacheTTLSeconds != nil {
+		seconds = *qm.CacheTTLSeconds
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// resultCache 按最近使用淘汰的查询结果缓存，保存的是转换后的 frame，命中时不需要再次转换
+type resultCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	frames  data.Frames
+	expires time.Time
+}
+
+func newResultCache(maxEntries int) *resultCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	return &resultCache{maxEntries: maxEntries, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *resultCache) get(key string, now time.Time) (data.Frames, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if now.After(entry.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return copyFrames(entry.frames), true
+}
+
+func (c *resultCache) set(key string, frames data.Frames, ttl time.Duration, now time.Time) {
+	if c == nil || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, frames: copyFrames(frames), expires: now.Add(ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	for c.ll.Len() > c.maxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate 清空缓存，返回清除的结果数
+func (c *resultCache) invalidate() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.ll.Len()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	return n
+}
+
+// copyFrames 复制 frame 和元数据，列的数据是共享的，缓存中的 frame 不会被修改
+func copyFrames(frames data.Frames) data.Frames {
+	out := make(data.Frames, len(frames))
+	for i, f := range frames {
+		copied := *f
+		copied.Fields = append([]*data.Field{}, f.Fields...)
+		if f.Meta != nil {
+			meta := *f.Meta
+			copied.Meta = &meta
+		}
+		out[i] = &copied
+	}
+	return out
+}
+
+// cacheKey 由 inflightKey 的组成部分和影响结果转换的选项组成
+// 时间范围和脚本中由时间范围展开的字面量按 resolution 向下取整，时间范围随刷新移动时在 resolution 内可以命中缓存
+// 脚本中其他的时间字面量是用户写的固定条件，保持原样，不同的条件不能共享结果
+func cacheKey(poolKey string, q backend.DataQuery, qm queryModel, script string, resolution time.Duration) string {
+	if resolution < time.Second {
+		resolution = time.Second
+	}
+	rounded := q
+	rounded.TimeRange.From = q.TimeRange.From.Truncate(resolution)
+	rounded.TimeRange.To = q.TimeRange.To.Truncate(resolution)
+	script = timeLiteralRegexp.ReplaceAllStringFunc(script, func(literal string) string {
+		t, err := parseDDBTime(literal)
+		if err != nil {
+			return literal
+		}
+		switch {
+		case t.Equal(q.TimeRange.From):
+			return "$__from:" + rounded.TimeRange.From.Format(ddbTimestampLayout)
+		case t.Equal(q.TimeRange.To):
+			return "$__to:" + rounded.TimeRange.To.Format(ddbTimestampLayout)
+		}
+		return literal
+	})
+	options, _ := json.Marshal(struct {
+		RefID       string
+		QueryType   string
+		ArrayVector string
+		Format      string
+		Logs        logsQueryModel
+		Annotation  annotationMappingModel
+		Downsample  string
+		MaxPoints   int64
+		Fill        fillQueryModel
+		Interval    time.Duration
+	}{q.RefID, q.QueryType, qm.ArrayVector, qm.Format, qm.Logs, qm.Annotation, qm.Downsample, q.MaxDataPoints, qm.Fill, q.Interval})
+	return inflightKey(poolKey, rounded, qm, script) + "\x00" + string(options)
+}
+
+type cacheInvalidateResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// 清空缓存会让所有面板重新查询数据库，和写入数据一样只允许编辑者和管理员操作
+var cacheInvalidateRoles = []string{"Editor", "Admin"}
+
+// handleCacheInvalidate 清空这个数据源的结果缓存
+func (d *Datasource) handleCacheInvalidate(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if req.Method != http.MethodPost {
+		return sendErrorResponse(sender, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", req.Method))
+	}
+	if !hasRole(req.PluginContext.User, cacheInvalidateRoles) {
+		return sendErrorResponse(sender, http.StatusForbidden, errors.New("only editors and admins can invalidate the cache"))
+	}
+	return sendJSONResponse(sender, cacheInvalidateResponse{Invalidated: d.cache.invalidate()})
+}
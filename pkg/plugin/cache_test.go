@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestResultCache(t *testing.T) {
+	c := newResultCache(2)
+	now := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	frame := data.NewFrame("A", data.NewField("value", nil, []int64{1}))
+	frame.Meta = &data.FrameMeta{ExecutedQueryString: "select 1"}
+
+	c.set("a", data.Frames{frame}, time.Minute, now)
+	c.set("b", data.Frames{frame}, time.Minute, now)
+	if _, ok := c.get("a", now); !ok {
+		t.Fatal("expected cache hit")
+	}
+	// b 最久没有使用，被淘汰
+	c.set("c", data.Frames{frame}, time.Minute, now)
+	if _, ok := c.get("b", now); ok {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	if _, ok := c.get("a", now.Add(2*time.Minute)); ok {
+		t.Fatal("expected expired entry to miss")
+	}
+
+	// 命中的结果是副本，修改元数据不影响缓存
+	frames, ok := c.get("c", now)
+	if !ok {
+		t.Fatal("expected cache hit")
+	}
+	frames[0].Meta.Custom = queryMeta{Cache: cacheHit}
+	frames, _ = c.get("c", now)
+	if frames[0].Meta.Custom != nil {
+		t.Fatal("cached frame metadata was modified")
+	}
+
+	if n := c.invalidate(); n != 1 {
+		t.Fatalf("expected 1 invalidated entry, got %d", n)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	query := func(from time.Time, to time.Time) backend.DataQuery {
+		return backend.DataQuery{RefID: "A", TimeRange: backend.TimeRange{From: from, To: to}}
+	}
+	base := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	script := func(from time.Time, to time.Time) string {
+		return "select * from t where ts between pair(" + from.Format("2006.01.02 15:04:05.000") + ", " + to.Format("2006.01.02 15:04:05.000") + ")"
+	}
+
+	from, to := base.Add(2*time.Second), base.Add(time.Hour+2*time.Second)
+	k1 := cacheKey("uid", query(from, to), queryModel{}, script(from, to), 10*time.Second)
+	from2, to2 := from.Add(5*time.Second), to.Add(5*time.Second)
+	k2 := cacheKey("uid", query(from2, to2), queryModel{}, script(from2, to2), 10*time.Second)
+	if k1 != k2 {
+		t.Fatal("time ranges within the same resolution should share a key")
+	}
+	from3, to3 := from.Add(10*time.Second), to.Add(10*time.Second)
+	if k1 == cacheKey("uid", query(from3, to3), queryModel{}, script(from3, to3), 10*time.Second) {
+		t.Fatal("time ranges in different buckets should not share a key")
+	}
+	if k1 == cacheKey("uid/alice", query(from, to), queryModel{}, script(from, to), 10*time.Second) {
+		t.Fatal("different identities should not share a key")
+	}
+	if k1 == cacheKey("uid", query(from, to), queryModel{ArrayVector: "expand"}, script(from, to), 10*time.Second) {
+		t.Fatal("different conversion options should not share a key")
+	}
+
+	// 用户写的固定时间条件不取整
+	fixed := func(literal string) string {
+		return cacheKey("uid", query(from, to), queryModel{}, script(from, to)+" and t > "+literal, time.Minute)
+	}
+	if fixed("2024.01.02 09:30:10") == fixed("2024.01.02 09:30:50") {
+		t.Fatal("different fixed time literals should not share a key")
+	}
+	if fixed("2024.01.02 09:30:10") != fixed("2024.01.02 09:30:10") {
+		t.Fatal("identical queries should share a key")
+	}
+}
+
+// statusSender 记录资源请求返回的状态码
+type statusSender func(status int)
+
+func (f statusSender) Send(resp *backend.CallResourceResponse) error {
+	f(resp.Status)
+	return nil
+}
+
+func TestHandleCacheInvalidate(t *testing.T) {
+	d := &Datasource{cache: newResultCache(2)}
+	cases := []struct {
+		method string
+		user   *backend.User
+		status int
+	}{
+		{http.MethodGet, &backend.User{Role: "Admin"}, http.StatusMethodNotAllowed},
+		{http.MethodPost, nil, http.StatusForbidden},
+		{http.MethodPost, &backend.User{Login: "v", Role: "Viewer"}, http.StatusForbidden},
+		{http.MethodPost, &backend.User{Login: "e", Role: "Editor"}, http.StatusOK},
+		{http.MethodPost, &backend.User{Login: "a", Role: "Admin"}, http.StatusOK},
+	}
+	for _, c := range cases {
+		now := time.Now()
+		d.cache.set("k", data.Frames{data.NewFrame("A")}, time.Minute, now)
+		var status int
+		err := d.handleCacheInvalidate(&backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{User: c.user},
+			Method:        c.method,
+			Path:          "cache/invalidate",
+		}, statusSender(func(s int) { status = s }))
+		if err != nil || status != c.status {
+			t.Errorf("%s as %v: expected %d, got %d %v", c.method, c.user, c.status, status, err)
+		}
+		if _, cached := d.cache.get("k", now); cached != (c.status != http.StatusOK) {
+			t.Errorf("%s as %v: the cache must only be cleared when allowed", c.method, c.user)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 一个查询最多拆分的块数
+const maxChunks = 100
+
+// 按分区边界对齐时支持的粒度，和常见的按日期、月份、年份的值分区对应，按面板时区的墙上时间计算
+const (
+	chunkAlignDay   = "day"
+	chunkAlignMonth = "month"
+	chunkAlignYear  = "year"
+)
+
+// chunkQueryModel 把查询的时间范围拆分为多个块并行执行，脚本中需要用 $__timeFilter 等宏引用时间范围
+type chunkQueryModel struct {
+	// 平均拆分的块数
+	Count int `json:"count,omitempty"`
+	// 按 day、month 或 year 的边界拆分，设置时忽略 Count
+	Align string `json:"align,omitempty"`
+}
+
+func (m chunkQueryModel) enabled() bool {
+	return m.Count > 1 || m.Align != ""
+}
+
+// splitTimeRange 拆分时间范围，除最后一块外每块都是左闭右开的区间 [from, to)
+// $__timeFilter 展开为闭区间的 pair，所以右开的结束时间表示为 to 减去 1 纳秒，DolphinDB 中最精确的时间类型是纳秒，
+// 这和 >= from and < to 等价，NANOTIMESTAMP 的列中边界前不到 1 毫秒的行也不会漏掉
+// interval 大于 0 时边界向下对齐到 interval 的整数倍，和 bar 等按间隔分组的结果一致，同一个时间桶不会被拆到相邻的两块中
+func splitTimeRange(tr backend.TimeRange, m chunkQueryModel, interval time.Duration) ([]backend.TimeRange, error) {
+	var bounds []time.Time
+	from, to := tr.From, tr.To
+	switch m.Align {
+	case "":
+		count := m.Count
+		if count > maxChunks {
+			return nil, fmt.Errorf("a query can be split into at most %d chunks", maxChunks)
+		}
+		step := to.Sub(from) / time.Duration(count)
+		step = step.Truncate(time.Millisecond)
+		if interval > 0 && step > 0 && step%interval != 0 {
+			step = (step/interval + 1) * interval
+		}
+		if step <= 0 {
+			return []backend.TimeRange{tr}, nil
+		}
+		for i := 1; i < count; i++ {
+			bounds = append(bounds, from.Add(step*time.Duration(i)))
+		}
+	case chunkAlignDay, chunkAlignMonth, chunkAlignYear:
+		for b := nextBoundary(from, m.Align); b.Before(to); b = nextBoundary(b, m.Align) {
+			bounds = append(bounds, b)
+			if len(bounds) >= maxChunks {
+				return nil, fmt.Errorf("time range spans more than %d %ss, use a coarser alignment", maxChunks, m.Align)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown chunk alignment %s", m.Align)
+	}
+
+	ranges := make([]backend.TimeRange, 0, len(bounds)+1)
+	start := from
+	for _, b := range bounds {
+		if interval > 0 {
+			b = alignTime(b, interval)
+		}
+		// 对齐后和前一个边界重合或者超出范围的边界不再拆分
+		if !b.After(start) || !b.Before(to) {
+			continue
+		}
+		ranges = append(ranges, backend.TimeRange{From: start, To: b.Add(-time.Nanosecond)})
+		start = b
+	}
+	return append(ranges, backend.TimeRange{From: start, To: to}), nil
+}
+
+// nextBoundary 返回 t 之后的第一个分区边界，t 为 ddbTime 转换后的墙上时间
+func nextBoundary(t time.Time, align string) time.Time {
+	y, mon, d := t.Date()
+	switch align {
+	case chunkAlignMonth:
+		return time.Date(y, mon+1, 1, 0, 0, 0, 0, time.UTC)
+	case chunkAlignYear:
+		return time.Date(y+1, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(y, mon, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+type chunkResult struct {
+	frames data.Frames
+	err    error
+}
+
+// queryChunks 并行执行每一块，按时间顺序拼接结果
+// 部分块失败时返回成功的块拼接的结果，并在 frame 的 notice 中列出失败的块
+func queryChunks(ctx context.Context, q backend.DataQuery, ranges []backend.TimeRange, run rangeQueryRunner) (data.Frames, error) {
+	results := make([]chunkResult, len(ranges))
+	var wg sync.WaitGroup
+	for i, tr := range ranges {
+		wg.Add(1)
+		go func(i int, tr backend.TimeRange) {
+			defer wg.Done()
+			chunk := q
+			chunk.TimeRange = tr
+			frames, err := run(ctx, chunk)
+			if err == nil && len(frames) != 1 {
+				err = fmt.Errorf("expected a single table, got %d frames", len(frames))
+			}
+			results[i] = chunkResult{frames: frames, err: err}
+		}(i, tr)
+	}
+	wg.Wait()
+
+	var merged *data.Frame
+	var notices []data.Notice
+	var errs []error
+	for i, r := range results {
+		err := r.err
+		if err == nil && merged != nil && !sameSchema(merged, r.frames[0]) {
+			err = errors.New("result columns differ from the previous chunks")
+		}
+		if err != nil {
+			err = fmt.Errorf("chunk %d/%d (%s - %s) failed: %w", i+1, len(ranges),
+				formatDDBTime(ranges[i].From), formatDDBTime(ranges[i].To), err)
+			errs = append(errs, err)
+			notices = append(notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: err.Error()})
+			continue
+		}
+		frame := r.frames[0]
+		if merged == nil {
+			merged = frame.EmptyCopy()
+			merged.Meta = frame.Meta
+		}
+		for row := 0; row < frame.Rows(); row++ {
+			for j, f := range frame.Fields {
+				merged.Fields[j].Append(f.At(row))
+			}
+		}
+	}
+	if merged == nil {
+		return nil, errors.Join(errs...)
+	}
+	if merged.Meta == nil {
+		merged.Meta = &data.FrameMeta{}
+	}
+	merged.Meta.Notices = append(merged.Meta.Notices, notices...)
+	return data.Frames{merged}, nil
+}
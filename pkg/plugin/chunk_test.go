@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestSplitTimeRange(t *testing.T) {
+	from := time.Date(2024, 1, 30, 12, 0, 0, 0, time.UTC)
+	tr := backend.TimeRange{From: from, To: from.Add(3 * 24 * time.Hour)}
+
+	ranges, err := splitTimeRange(tr, chunkQueryModel{Count: 3}, 0)
+	if err != nil || len(ranges) != 3 {
+		t.Fatalf("unexpected ranges %v %v", ranges, err)
+	}
+	if !ranges[1].From.Equal(from.Add(24*time.Hour)) || !ranges[0].To.Equal(from.Add(24*time.Hour-time.Nanosecond)) || !ranges[2].To.Equal(tr.To) {
+		t.Fatalf("unexpected ranges %v", ranges)
+	}
+
+	ranges, err = splitTimeRange(tr, chunkQueryModel{Align: chunkAlignMonth}, 0)
+	if err != nil || len(ranges) != 2 || !ranges[1].From.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected month ranges %v %v", ranges, err)
+	}
+	ranges, _ = splitTimeRange(tr, chunkQueryModel{Align: chunkAlignDay}, 0)
+	if len(ranges) != 4 {
+		t.Fatalf("expected 4 daily chunks, got %d", len(ranges))
+	}
+	if _, err := splitTimeRange(backend.TimeRange{From: from, To: from.AddDate(1, 0, 0)}, chunkQueryModel{Align: chunkAlignDay}, 0); err == nil {
+		t.Fatal("expected too many chunks error")
+	}
+}
+
+func TestSplitTimeRangeSubMillisecond(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ranges, err := splitTimeRange(backend.TimeRange{From: from, To: from.Add(2 * time.Hour)}, chunkQueryModel{Count: 2}, 0)
+	if err != nil || len(ranges) != 2 {
+		t.Fatalf("unexpected ranges %v %v", ranges, err)
+	}
+	// NANOTIMESTAMP 的行在边界前 0.5 毫秒，只属于第一块；在边界上的行只属于第二块
+	boundary := from.Add(time.Hour)
+	contains := func(tr backend.TimeRange, ts time.Time) bool { return !ts.Before(tr.From) && !ts.After(tr.To) }
+	for _, c := range []struct {
+		ts     time.Time
+		chunks []bool
+	}{
+		{boundary.Add(-500 * time.Microsecond), []bool{true, false}},
+		{boundary.Add(-time.Nanosecond), []bool{true, false}},
+		{boundary, []bool{false, true}},
+	} {
+		for i, tr := range ranges {
+			if contains(tr, c.ts) != c.chunks[i] {
+				t.Fatalf("row at %s: chunk %d contains it = %v", c.ts.Format(ddbNanotimestampLayout), i, !c.chunks[i])
+			}
+		}
+	}
+	if got := expandMacros("$__timeFilter", ranges[0], time.Minute); got != "pair(2024.01.01T00:00:00.000, 2024.01.01T00:59:59.999999999)" {
+		t.Fatalf("unexpected script %s", got)
+	}
+}
+
+func TestSplitTimeRangeInterval(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 2, 30, 0, time.UTC)
+	tr := backend.TimeRange{From: from, To: from.Add(time.Hour)}
+
+	ranges, err := splitTimeRange(tr, chunkQueryModel{Count: 4}, 5*time.Minute)
+	if err != nil || len(ranges) != 4 {
+		t.Fatalf("unexpected ranges %v %v", ranges, err)
+	}
+	for i, want := range []time.Time{from, from.Add(12*time.Minute + 30*time.Second), from.Add(27*time.Minute + 30*time.Second), from.Add(42*time.Minute + 30*time.Second)} {
+		if !ranges[i].From.Equal(want) {
+			t.Fatalf("chunk %d: expected to start at %v, got %v", i, want, ranges[i].From)
+		}
+	}
+
+	// 块的长度不是间隔的整数倍时向上取整，每个内部边界都在时间桶的起点上，相邻的块首尾相接
+	for _, interval := range []time.Duration{7 * time.Minute, 10 * time.Minute, time.Hour} {
+		ranges, err := splitTimeRange(tr, chunkQueryModel{Count: 4}, interval)
+		if err != nil || !ranges[0].From.Equal(tr.From) || !ranges[len(ranges)-1].To.Equal(tr.To) {
+			t.Fatalf("%v: unexpected ranges %v %v", interval, ranges, err)
+		}
+		for i := 1; i < len(ranges); i++ {
+			if b := ranges[i].From; !alignTime(b, interval).Equal(b) || !ranges[i-1].To.Equal(b.Add(-time.Nanosecond)) {
+				t.Fatalf("%v: chunk %d starts inside a bucket: %v", interval, i, ranges)
+			}
+		}
+	}
+	// 间隔大于每块的长度时，对齐后重合的边界合并，范围内没有时间桶的起点时不拆分
+	if ranges, _ := splitTimeRange(tr, chunkQueryModel{Count: 4}, time.Hour); len(ranges) != 2 {
+		t.Fatalf("expected a single seam at 01:00, got %v", ranges)
+	}
+	if ranges, _ := splitTimeRange(tr, chunkQueryModel{Count: 4}, 2*time.Hour); len(ranges) != 1 {
+		t.Fatalf("expected no split, got %v", ranges)
+	}
+}
+
+func TestQueryChunks(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ranges, _ := splitTimeRange(backend.TimeRange{From: from, To: from.Add(3 * time.Hour)}, chunkQueryModel{Count: 3}, 0)
+	run := func(_ context.Context, q backend.DataQuery) (data.Frames, error) {
+		if q.TimeRange.From.Equal(from.Add(time.Hour)) {
+			return nil, errors.New("timeout")
+		}
+		return data.Frames{data.NewFrame("A",
+			data.NewField("time", nil, []time.Time{q.TimeRange.From}),
+			data.NewField("value", nil, []float64{float64(q.TimeRange.From.Hour())}),
+		)}, nil
+	}
+	frames, err := queryChunks(context.Background(), backend.DataQuery{RefID: "A"}, ranges, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := frames[0]
+	if frame.Rows() != 2 || frame.Fields[1].At(0).(float64) != 0 || frame.Fields[1].At(1).(float64) != 2 {
+		t.Fatalf("unexpected merged frame %v", frame)
+	}
+	if len(frame.Meta.Notices) != 1 || !strings.Contains(frame.Meta.Notices[0].Text, "chunk 2/3") {
+		t.Fatalf("expected a notice for the failed chunk, got %v", frame.Meta.Notices)
+	}
+
+	failing := func(context.Context, backend.DataQuery) (data.Frames, error) { return nil, errors.New("timeout") }
+	if _, err := queryChunks(context.Background(), backend.DataQuery{}, ranges, failing); err == nil {
+		t.Fatal("expected an error when every chunk fails")
+	}
+}
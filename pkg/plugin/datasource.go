@@ -3,12 +3,11 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net/http"
 	"path"
-	"reflect"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -44,10 +43,25 @@ func NewDatasource(_ context.Context, s backend.DataSourceInstanceSettings) (ins
 		return nil, err
 	}
 
-	return &Datasource{
+	ds := &Datasource{
 		channelPrefix: path.Join("ds", s.UID),
 		uri:           settings.URI,
-	}, nil
+		uid:           s.UID,
+		rates:         newRateTracker(),
+		inflight:      newInflightGroup(),
+		incremental:   newIncrementalStore(),
+	}
+	if cacheSettings, err := parseCacheSettings(s.JSONData); err == nil {
+		ds.cache = newResultCache(cacheSettings.CacheMaxEntries)
+	}
+
+	// 清理插件之前（比如崩溃或重启前）创建后遗留在发布端的订阅
+	if config, err := parseJSONData(s.JSONData); err == nil && config.URL != "" {
+		ds.config = config
+		go cleanupStreamActions(ds.uid, ds.config, "")
+	}
+
+	return ds, nil
 }
 
 func getDatasourceSettings(s backend.DataSourceInstanceSettings) (*Options, error) {
@@ -65,6 +79,16 @@ func getDatasourceSettings(s backend.DataSourceInstanceSettings) (*Options, erro
 type Datasource struct {
 	channelPrefix string
 	uri           string
+	uid           string
+	config        db.DBConfig
+	// 流数据拓扑中计数器上一次的值，用来计算吞吐量
+	rates *rateTracker
+	// 正在执行的查询，相同的查询共享结果
+	inflight *inflightGroup
+	// 转换后的查询结果的缓存
+	cache *resultCache
+	// 增量查询上次的结果
+	incremental *incrementalStore
 }
 
 type Options struct {
@@ -76,6 +100,10 @@ type Options struct {
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (d *Datasource) Dispose() {
 	// Clean up datasource instance resources.
+	// 取消这个数据源遗留的、已经没有 RunStream 在使用的订阅
+	if d.config.URL != "" {
+		go cleanupStreamActions(d.uid, d.config, "")
+	}
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -104,22 +132,75 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	response := backend.NewQueryDataResponse()
 	var mu sync.Mutex // mutex to protect concurrent access to response
 
-	// parse datasource settings once
-	config, err := parseJSONData(req.PluginContext.DataSourceInstanceSettings.JSONData)
+	// 没有数据源配置就没法连接，每个查询都返回错误
+	if req.PluginContext.DataSourceInstanceSettings == nil {
+		for _, q := range req.Queries {
+			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, "missing datasource instance settings")
+		}
+		return response, nil
+	}
+
+	// parse datasource settings once，映射了 DolphinDB 用户时 uid 为这个用户的连接池的 key
+	uid, config, err := datasourceConfig(req.PluginContext)
+	if err != nil {
+		for _, q := range req.Queries {
+			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		return response, nil
+	}
+
+	readOnly, err := parseReadOnlySettings(req.PluginContext.DataSourceInstanceSettings.JSONData)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按数据源的并发限制调度，调度器按数据源区分，不按映射的用户区分
+	schedulerSettings, err := parseSchedulerSettings(req.PluginContext.DataSourceInstanceSettings.JSONData)
+	if err != nil {
+		return nil, err
+	}
+	var scheduler *db.Scheduler
+	if schedulerSettings.MaxConcurrentQueries > 0 {
+		scheduler = db.GetScheduler(req.PluginContext.DataSourceInstanceSettings.UID, schedulerSettings.config())
+	}
+	// 单独执行的查询（增量查询等）也经过调度器
+	runner := queryRunner{scheduler: scheduler, uid: uid, config: config, user: queryUser(req.PluginContext.User), headers: req.Headers}
+
+	cacheSettings, err := parseCacheSettings(req.PluginContext.DataSourceInstanceSettings.JSONData)
 	if err != nil {
 		return nil, err
 	}
+	// 告警查询总是读取最新的数据，不使用缓存
+	fromAlert := req.Headers[fromAlertHeader] == "true"
+	now := time.Now()
+	cacheKeys := make(map[*api.Task]string)
+	cacheTTLs := make(map[*api.Task]time.Duration)
 
 	// create a slice to hold all tasks
-	tasks := make([]*api.Task, len(req.Queries))
+	tasks := make([]*api.Task, 0, len(req.Queries))
 	queryMap := make(map[*api.Task]backend.DataQuery)
+	modelMap := make(map[*api.Task]queryModel)
+
+	// 临时过滤条件的列类型只在有查询用到时读取一次
+	var adhocTypes map[string]db.ColumnType
+	adhocTypesLoaded := false
+	loadAdhocTypes := func() map[string]db.ColumnType {
+		if !adhocTypesLoaded {
+			adhocTypesLoaded = true
+			if settings, err := parseAdhocSettings(req.PluginContext.DataSourceInstanceSettings.JSONData); err == nil {
+				adhocTypes = adhocColumnTypes(settings, uid, config)
+			}
+		}
+		return adhocTypes
+	}
 
 	// create tasks for all queries
-	for i, q := range req.Queries {
+	for _, q := range req.Queries {
 		var qm queryModel
 		err := json.Unmarshal(q.JSON, &qm)
 		if err != nil {
 			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("json unmarshal: %v", err.Error()))
+			continue
 		}
 
 		// skip hidden queries
@@ -127,48 +208,211 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 			continue
 		}
 
-		task := &api.Task{Script: qm.QueryText}
-		tasks[i] = task
+		// 后端展开的宏、拆分的块和补齐的时间点都按面板时区的墙上时间计算，和前端以及 DolphinDB 中的时间一致
+		loc, err := queryLocation(qm.Timezone)
+		if err != nil {
+			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
+		}
+		q.TimeRange = ddbTimeRange(q.TimeRange, loc)
+
+		// 只读模式检查用户填写的脚本，构建器和监控查询的脚本由插件生成
+		if err := readOnly.checkScript(qm.QueryText); err != nil {
+			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusForbidden, err.Error())
+			continue
+		}
+
+		// 流数据拓扑需要多次查询，不走连接池的批量任务
+		if q.QueryType == queryTypeStreamingTopology {
+			frames, err := d.queryTopology(uid, config)
+			if err != nil {
+				response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Error querying streaming topology: %v", err))
+			} else {
+				response.Responses[q.RefID] = backend.DataResponse{Frames: frames}
+			}
+			continue
+		}
+
+		// 构建器的过滤值按表的列类型生成字面量，增量、拆分和普通查询都需要
+		if q.QueryType == queryTypeBuilder {
+			qm.Builder.loadColumnTypes(uid, config)
+		}
+
+		if usesAdhocFilters(qm) {
+			var types map[string]db.ColumnType
+			if len(qm.AdhocFilters) > 0 {
+				types = loadAdhocTypes()
+			}
+			qm.QueryText, err = expandAdhocFilters(qm.QueryText, qm.AdhocFilters, types)
+			if err != nil {
+				response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+				continue
+			}
+		}
+
+		// 增量查询单独执行，只查询上次结果之后的新数据
+		if qm.Incremental.Enabled && q.QueryType == "" {
+			key := incrementalKey(uid, q, qm)
+			frames, mode, err := queryIncremental(ctx, d.incremental, key, q, qm.Incremental, func(ctx context.Context, q backend.DataQuery) (data.Frames, error) {
+				return runner.run(ctx, q, qm)
+			}, time.Now())
+			if err == nil {
+				frames, err = shapeTimeSeries(frames, q, qm)
+			}
+			if err != nil {
+				response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+				continue
+			}
+			for _, f := range frames {
+				f.Meta.Custom = queryMeta{Incremental: mode}
+			}
+			response.Responses[q.RefID] = backend.DataResponse{Frames: frames}
+			continue
+		}
+
+		// 时间范围很长的查询拆分为多块并行执行
+		if qm.Chunks.enabled() && q.QueryType == "" {
+			ranges, err := splitTimeRange(q.TimeRange, qm.Chunks, q.Interval)
+			if err == nil {
+				var frames data.Frames
+				frames, err = queryChunks(ctx, q, ranges, func(ctx context.Context, q backend.DataQuery) (data.Frames, error) {
+					return runner.run(ctx, q, qm)
+				})
+				if err == nil {
+					frames, err = shapeTimeSeries(frames, q, qm)
+				}
+				if err == nil {
+					for _, f := range frames {
+						f.Meta.Custom = queryMeta{Chunks: len(ranges)}
+					}
+					response.Responses[q.RefID] = backend.DataResponse{Frames: frames}
+					continue
+				}
+			}
+			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
+		}
+
+		var task *api.Task
+		if q.QueryType == queryTypeFunction {
+			// 和脚本一样，只读模式拒绝的函数返回 403
+			if err := readOnly.checkFunction(qm.Function.Name); err != nil {
+				response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusForbidden, err.Error())
+				continue
+			}
+			task, err = functionTask(q, qm.Function, loc)
+		} else {
+			var script string
+			script, err = buildScript(q, qm)
+			task = &api.Task{Script: script}
+		}
+		if err != nil {
+			response.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
+		}
+
+		if ttl := cacheTTL(cacheSettings, qm); ttl > 0 && !fromAlert {
+			key := cacheKey(uid, q, qm, task.Script, ttl)
+			if frames, ok := d.cache.get(key, now); ok {
+				for _, f := range frames {
+					f.Meta.Custom = queryMeta{Cache: cacheHit}
+				}
+				response.Responses[q.RefID] = backend.DataResponse{Frames: frames}
+				continue
+			}
+			cacheKeys[task] = key
+			cacheTTLs[task] = ttl
+		}
+
+		tasks = append(tasks, task)
 		queryMap[task] = q
+		modelMap[task] = qm
 	}
 
-	// execute all tasks in parallel using the connection pool
-	err = db.RunPoolTasks(tasks, req.PluginContext.DataSourceInstanceSettings.UID, config)
-	isRunPoolTaskError := false
-	runPoolTaskErrorStr := ""
-	if err != nil {
-		isRunPoolTaskError = true
-		runPoolTaskErrorStr = err.Error()
+	// 没有需要执行的查询
+	if len(tasks) == 0 {
+		return response, nil
 	}
 
-	// process results
-	for _, task := range tasks {
-		if task == nil {
+	// 合并同时执行的相同查询，包括同一个请求中的和其他用户的请求中的，只有 leader 真正执行
+	keys := make([]string, len(tasks))
+	calls := make([]*inflightCall, len(tasks))
+	metas := make([]queryMeta, len(tasks))
+	scheduled := make([]db.ScheduledTask, 0, len(tasks))
+	leaders := make([]int, 0, len(tasks))
+	for i, task := range tasks {
+		keys[i] = inflightKey(uid, queryMap[task], modelMap[task], task.Script)
+		call, leader := d.inflight.join(keys[i])
+		calls[i] = call
+		if !leader {
+			metas[i] = queryMeta{Dedup: dedupShared}
 			continue
 		}
+		leaders = append(leaders, i)
+		scheduled = append(scheduled, runner.scheduled(task, modelMap[task]))
+	}
 
+	// execute all tasks in parallel using the connection pool
+	errs := db.RunScheduledTasks(ctx, scheduler, scheduled, uid, config)
+	for j, i := range leaders {
+		result, err := taskResult(tasks[i], errs[j])
+		shared := d.inflight.finish(keys[i], calls[i], result, err)
+		metas[i] = queryMeta{Dedup: dedupExecuted, SharedWith: shared}
+	}
+
+	// process results
+	for i, task := range tasks {
 		q := queryMap[task]
 		var res backend.DataResponse
 
-		if isRunPoolTaskError {
-			res = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Error running connection pool tasks: %v", runPoolTaskErrorStr))
+		result, err := calls[i].wait(ctx)
+		if err != nil && metas[i].Dedup == dedupShared && isCancellation(err) && ctx.Err() == nil {
+			// 共享的 leader 所在的请求被取消了，这个请求还在等待结果，重新执行
+			result, metas[i], err = d.inflight.do(ctx, keys[i], func() (model.DataForm, error) {
+				return runner.execute(ctx, task, modelMap[task])
+			})
+		}
+		if err != nil {
+			status := backend.StatusBadRequest
+			if errors.Is(err, db.ErrQueueTimeout) {
+				status = backend.StatusTooManyRequests
+			}
+			res = backend.ErrDataResponse(status, err.Error())
 			mu.Lock()
 			response.Responses[q.RefID] = res
 			mu.Unlock()
-			// 整个任务执行都不行了，下面的就别干了
 			continue
 		}
 
-		if task.IsSuccess() {
-			data := task.GetResult()
-			frame, err := db.TransformDataForm(data, fmt.Sprintf("", q.RefID))
-			if err != nil {
-				res = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Error transforming dataform: %v", err.Error()))
-			} else {
-				res.Frames = append(res.Frames, frame)
-			}
+		// 共享的结果只读，每个查询按自己的选项单独转换
+		qm := modelMap[task]
+		frame, err := db.TransformDataFormWithOptions(result, q.RefID, db.TransformOptions{ArrayVector: qm.ArrayVector})
+		var frames data.Frames
+		if err == nil {
+			frames, err = postProcess(frame, q, qm)
+		}
+		if err == nil {
+			frames, err = shapeTimeSeries(frames, q, qm)
+		}
+		if err != nil {
+			res = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Error transforming dataform: %v", err.Error()))
 		} else {
-			res = backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("Error run query task: %v", task.GetError()))
+			key, cacheable := cacheKeys[task]
+			if cacheable {
+				metas[i].Cache = cacheMiss
+			}
+			// 在 frame 的元数据中返回实际执行的脚本，查询检查器中可以看到构建器编译出的脚本
+			for _, f := range frames {
+				if f.Meta == nil {
+					f.Meta = &data.FrameMeta{}
+				}
+				f.Meta.ExecutedQueryString = task.Script
+				f.Meta.Custom = metas[i]
+			}
+			if cacheable {
+				d.cache.set(key, frames, cacheTTLs[task], now)
+			}
+			res.Frames = append(res.Frames, frames...)
 		}
 
 		mu.Lock()
@@ -179,18 +423,212 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 	return response, nil
 }
 
+// buildScript 生成查询实际执行的脚本
+func buildScript(q backend.DataQuery, qm queryModel) (string, error) {
+	script := qm.QueryText
+	if q.QueryType == queryTypeBuilder {
+		compiled, err := compileBuilderQuery(qm.Builder)
+		if err != nil {
+			return "", err
+		}
+		script = compiled
+	}
+	// 前端没有展开的宏（告警、注释等查询）在这里用查询的时间范围展开
+	script = expandMacros(script, q.TimeRange, q.Interval)
+	switch q.QueryType {
+	case queryTypeLogsVolume:
+		return logsVolumeScript(qm.Logs, script, q.TimeRange, q.Interval)
+	case queryTypeLogsContext:
+		loc, err := queryLocation(qm.Timezone)
+		if err != nil {
+			return "", err
+		}
+		return logsContextScript(qm.Logs, loc)
+	}
+	if isMonitorQueryType(q.QueryType) {
+		return monitorScript(q.QueryType, qm.Monitor)
+	}
+	return script, nil
+}
+
+// postProcess 按查询类型和格式把查询结果转换为最终返回的 frame
+func postProcess(frame *data.Frame, q backend.DataQuery, qm queryModel) (data.Frames, error) {
+	switch {
+	case q.QueryType == queryTypeAnnotation:
+		frame, err := toAnnotationFrame(frame, qm.Annotation)
+		return data.Frames{frame}, err
+	case q.QueryType == queryTypeLogsVolume:
+		return toLogsVolumeFrames(frame, q.RefID)
+	case q.QueryType == queryTypeLogsContext || qm.Format == formatLogs:
+		frame, err := toLogsFrame(frame, qm.Logs)
+		return data.Frames{frame}, err
+	case isMonitorQueryType(q.QueryType):
+		loc, err := queryLocation(qm.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		return data.Frames{normalizeMonitorFrame(q.QueryType, frame, ddbTime(time.Now(), loc))}, nil
+	}
+	return data.Frames{frame}, nil
+}
+
+// shapeTimeSeries 对合并后的完整结果先补齐缺失的时间点，再降采样
+func shapeTimeSeries(frames data.Frames, q backend.DataQuery, qm queryModel) (data.Frames, error) {
+	frames, err := fillFrames(frames, q, qm)
+	if err != nil {
+		return nil, err
+	}
+	return downsampleFrames(frames, q, qm)
+}
+
+// queryRunner 单独执行一个查询，和批量执行的查询一样经过调度器、转换和后处理
+type queryRunner struct {
+	scheduler *db.Scheduler
+	uid       string
+	config    db.DBConfig
+	user      string
+	headers   map[string]string
+}
+
+// scheduled 按发起请求的用户和查询的优先级调度任务
+func (r queryRunner) scheduled(task *api.Task, qm queryModel) db.ScheduledTask {
+	return db.ScheduledTask{Task: task, User: r.user, Priority: queryPriority(r.headers, qm)}
+}
+
+// execute 通过调度器执行一个任务，返回原始的查询结果
+func (r queryRunner) execute(ctx context.Context, task *api.Task, qm queryModel) (model.DataForm, error) {
+	err := db.RunScheduledTasks(ctx, r.scheduler, []db.ScheduledTask{r.scheduled(task, qm)}, r.uid, r.config)[0]
+	return taskResult(task, err)
+}
+
+// taskResult 把执行的错误和任务本身的错误统一为一个 error
+func taskResult(task *api.Task, err error) (model.DataForm, error) {
+	if err != nil {
+		return nil, fmt.Errorf("Error running connection pool tasks: %w", err)
+	}
+	if !task.IsSuccess() {
+		return nil, fmt.Errorf("Error run query task: %v", task.GetError())
+	}
+	return task.GetResult(), nil
+}
+
+func (r queryRunner) run(ctx context.Context, q backend.DataQuery, qm queryModel) (data.Frames, error) {
+	script, err := buildScript(q, qm)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.execute(ctx, &api.Task{Script: script}, qm)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := db.TransformDataFormWithOptions(result, q.RefID, db.TransformOptions{ArrayVector: qm.ArrayVector})
+	if err != nil {
+		return nil, fmt.Errorf("Error transforming dataform: %w", err)
+	}
+	frames, err := postProcess(frame, q, qm)
+	if err != nil {
+		return nil, fmt.Errorf("Error transforming dataform: %w", err)
+	}
+	for _, f := range frames {
+		if f.Meta == nil {
+			f.Meta = &data.FrameMeta{}
+		}
+		f.Meta.ExecutedQueryString = script
+	}
+	return frames, nil
+}
+
 type queryModel struct {
-	QueryText     string     `json:"queryText"`
-	Constant      float64    `json:"constant"` // 保持 float64 类型
-	Datasource    Datasource `json:"datasource"`
-	IntervalMs    int        `json:"intervalMs"`
-	MaxDataPoints int        `json:"maxDataPoints"`
-	RefID         string     `json:"refId"`
-	Hide          bool       `json:"hide"`
-	Streaming     struct {
-		Table  string `json:"table"`
-		Action string `json:"action,omitempty"`
-	} `json:"streaming,omitempty"`
+	QueryText     string              `json:"queryText"`
+	Constant      float64             `json:"constant"` // 保持 float64 类型
+	Datasource    Datasource          `json:"datasource"`
+	IntervalMs    int                 `json:"intervalMs"`
+	MaxDataPoints int                 `json:"maxDataPoints"`
+	RefID         string              `json:"refId"`
+	Hide          bool                `json:"hide"`
+	Streaming     streamingQueryModel `json:"streaming,omitempty"`
+	// 数组向量列的展示方式，json 或 expand
+	ArrayVector string `json:"arrayVector,omitempty"`
+	// 注释查询的列映射
+	Annotation annotationMappingModel `json:"annotation,omitempty"`
+	// 结果的格式，table（默认）或 logs
+	Format string `json:"format,omitempty"`
+	// logs 格式的列配置，以及日志量和日志上下文查询的参数
+	Logs logsQueryModel `json:"logs,omitempty"`
+	// 集群监控查询的参数
+	Monitor monitorQueryModel `json:"monitor,omitempty"`
+	// 面板上生效的临时过滤条件，展开到 $__adhocFilters 中
+	AdhocFilters []adhocFilterModel `json:"adhocFilters,omitempty"`
+	// 函数调用查询的函数名和参数
+	Function functionCallModel `json:"function,omitempty"`
+	// 可视化查询构建器的结构化查询
+	Builder builderQueryModel `json:"builder,omitempty"`
+	// 发起查询的应用，例如 explore、dashboard，决定查询的调度优先级
+	App string `json:"app,omitempty"`
+	// 这个查询的结果缓存时间（秒），为空时使用数据源的配置，为 0 时不缓存
+	CacheTTLSeconds *int `json:"cacheTTLSeconds,omitempty"`
+	// 增量查询，只查询上次结果之后的新数据
+	Incremental incrementalQueryModel `json:"incremental,omitempty"`
+	// 把时间范围拆分为多块并行执行
+	Chunks chunkQueryModel `json:"chunks,omitempty"`
+	// 时间序列结果的降采样算法，lttb、minmax 或 avg，为空时不降采样
+	Downsample string `json:"downsample,omitempty"`
+	// 按查询的间隔对齐时间列并补齐缺失的时间点
+	Fill fillQueryModel `json:"fill,omitempty"`
+	// 面板的时区，例如 Asia/Shanghai，由前端填写，为空时按 UTC
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// 流数据推送模式
+const (
+	// 追加模式，每条消息作为新的行推送，默认
+	streamingModeAppend = "append"
+	// 最新值模式，按键列保留每个键的最新一行，定时推送全量快照替换旧的 frame
+	streamingModeLatest = "latest"
+	// 窗口聚合模式，在插件内做窗口聚合，只推送窗口结果
+	streamingModeWindow = "window"
+	// 历史回放模式，按原始节奏或加速读取历史数据推送
+	streamingModeReplay = "replay"
+)
+
+type streamingQueryModel struct {
+	Table string `json:"table"`
+	// 同时订阅多张流数据表，不为空时忽略 Table
+	Tables []string `json:"tables,omitempty"`
+	Action string   `json:"action,omitempty"`
+	Mode   string   `json:"mode,omitempty"`
+	// latest 模式下用来区分行的键列
+	KeyColumns []string `json:"keyColumns,omitempty"`
+	// latest 模式下推送快照的间隔，单位毫秒
+	SnapshotIntervalMs int `json:"snapshotIntervalMs,omitempty"`
+	// window 模式下的窗口配置
+	Window *streamingWindowModel `json:"window,omitempty"`
+	// replay 模式下的回放配置
+	Replay *streamingReplayModel `json:"replay,omitempty"`
+	// 订阅多张表时按时间列合并为一个 frame，为空时每张表推送各自的 frame
+	Merge *streamingMergeModel `json:"merge,omitempty"`
+}
+
+// tables 返回需要订阅的流数据表
+func (s streamingQueryModel) tables() []string {
+	if len(s.Tables) > 0 {
+		return s.Tables
+	}
+	return []string{s.Table}
+}
+
+// validTables 检查需要订阅的流数据表，表名会拼接到获取列名的脚本中，所以每张表都必须是合法的标识符
+func (s streamingQueryModel) validTables() ([]string, error) {
+	tables := s.tables()
+	if len(tables) > 1 && s.Mode != "" && s.Mode != streamingModeAppend {
+		return nil, fmt.Errorf("subscribing to multiple streaming tables is not supported in %s mode", s.Mode)
+	}
+	for _, table := range tables {
+		if !isValidIdentifier(table) {
+			return nil, fmt.Errorf("streaming table %s is not a valid identifier", table)
+		}
+	}
+	return tables, nil
 }
 
 func parseJSONData(jsonData json.RawMessage) (db.DBConfig, error) {
@@ -312,7 +750,7 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 		// 这里处理 metricFindQuery 的逻辑
 
 		// Plugin Config
-		config, err := parseJSONData(req.PluginContext.DataSourceInstanceSettings.JSONData)
+		uid, config, err := datasourceConfig(req.PluginContext)
 		if err != nil {
 			log.DefaultLogger.Error("Error parsing JSONData: %v", err)
 			return sendErrorResponse(sender, http.StatusBadRequest, err)
@@ -324,8 +762,15 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 			log.DefaultLogger.Error("Error parse metric find query: %v", err)
 			return sendErrorResponse(sender, http.StatusBadRequest, err)
 		}
+		readOnly, err := parseReadOnlySettings(req.PluginContext.DataSourceInstanceSettings.JSONData)
+		if err != nil {
+			return sendErrorResponse(sender, http.StatusBadRequest, err)
+		}
+		if err := readOnly.checkScript(queryModel.Query); err != nil {
+			return sendErrorResponse(sender, http.StatusForbidden, err)
+		}
 		task := &api.Task{Script: queryModel.Query}
-		err = db.RunPoolTasks([]*api.Task{task}, req.PluginContext.DataSourceInstanceSettings.UID, config)
+		err = db.RunPoolTasks([]*api.Task{task}, uid, config)
 		if err != nil {
 			log.DefaultLogger.Error("Error run task: %v", err)
 			return sendErrorResponse(sender, http.StatusBadRequest, err)
@@ -355,6 +800,15 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 		return sender.Send(&response)
 	}
 
+	switch req.Path {
+	case "tagKeys":
+		return d.handleTagKeys(req, sender)
+	case "tagValues":
+		return d.handleTagValues(req, sender)
+	case "cache/invalidate":
+		return d.handleCacheInvalidate(req, sender)
+	}
+
 	// NotFound
 	return sender.Send(&backend.CallResourceResponse{
 		Status: http.StatusNotFound,
@@ -388,133 +842,326 @@ func (d *Datasource) SubscribeStream(_ context.Context, req *backend.SubscribeSt
 	}, nil
 }
 
-// PublishStream just returns permission denied in this case, since in this example we don't want the user to send stream data.
-// Permissions verifications could be done here. Check backend.StreamHandler docs for more details.
-func (d *Datasource) PublishStream(context.Context, *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
-	return &backend.PublishStreamResponse{
-		Status: backend.PublishStreamStatusPermissionDenied,
-	}, nil
-}
-
 type Message struct {
 	Time  int64   `json:"time"`
 	Value float64 `json:"value"`
 }
 
+// latest 模式默认的快照推送间隔
+const defaultSnapshotInterval = time.Second
+
+func containsString(list []string, target string) bool {
+	for _, item := range list {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
+
+// ddbStreamingHandler 处理一个流查询订阅的所有流数据表的消息
 type ddbStreamingHandler struct {
-	Ch chan []*data.Field
-	tb (*model.Table)
+	Ch chan streamMessage
+	// 每张流数据表的结构，key 为表名
+	tables map[string]*model.Table
+	status *streamStatus
+	opts   db.TransformOptions
+}
+
+// tableOf 从消息的 topic 中取出表名，topic 的格式为 host:port:alias/tableName/actionName
+func (handler *ddbStreamingHandler) tableOf(msg streaming.IMessage) string {
+	topic := strings.Split(msg.GetTopic(), ",")[0]
+	parts := strings.Split(topic, "/")
+	if len(parts) >= 3 {
+		if _, ok := handler.tables[parts[len(parts)-2]]; ok {
+			return parts[len(parts)-2]
+		}
+	}
+	// 只订阅了一张表时不需要依赖 topic
+	if len(handler.tables) == 1 {
+		for table := range handler.tables {
+			return table
+		}
+	}
+	return ""
 }
 
 func (handler *ddbStreamingHandler) DoEvent(msg streaming.IMessage) {
+	table := handler.tableOf(msg)
+	tb, ok := handler.tables[table]
+	if !ok {
+		log.DefaultLogger.Warn("Streaming message from unknown table", "topic", msg.GetTopic())
+		return
+	}
+	// 订阅多张表时，提示中的列名带上表名
+	columnName := func(name string) string {
+		if len(handler.tables) > 1 {
+			return mergedColumnName(table, name)
+		}
+		return name
+	}
+
+	handler.status.checkOffset(table, msg.GetOffset())
+	if msg.Size() != len(tb.ColNames) {
+		handler.status.sizeMismatch(msg.Size(), len(tb.ColNames))
+	}
 
 	var fields []*data.Field
 	// 拼了
-	for _, name := range handler.tb.ColNames {
+	for _, name := range tb.ColNames {
 		colVal := msg.GetValueByName(name)
-		// 处理一下，不是 Scalar 就不要去转换并写入到 frame
-		valType := colVal.GetDataForm()
-		if valType != model.DfScalar {
+		if colVal == nil {
+			handler.status.schemaMismatch(columnName(name), "is missing from the streaming message")
 			continue
 		}
-		scType := colVal.(*model.Scalar).GetDataType()
-		// 必须满足目标类型，先建立目标类型的 Slice
-		retSlice := reflect.MakeSlice(reflect.SliceOf(reflect.PointerTo(db.GetTypeFromMap(scType))), 1, 1)
-		sc := colVal.(*model.Scalar).Value()
-		retVal, err := db.ConvertValue(sc, scType)
-		if err != nil || colVal.(*model.Scalar).IsNull() {
-			// 这个值就不存了
-		} else {
-			retSlice.Index(0).Set(retVal)
+		// 和查询结果使用同一套转换逻辑，数组向量等非 Scalar 的列按 opts 转换
+		colFields, err := db.TransformStreamValue(name, colVal, handler.opts)
+		if err != nil {
+			handler.status.schemaMismatch(columnName(name), fmt.Sprintf("is not supported and was skipped: %v", err))
+			continue
 		}
-		field := data.NewField(name, nil, retSlice.Interface())
-		fields = append(fields, field)
+		fields = append(fields, colFields...)
+	}
+
+	// 面板来不及消费时丢弃消息，不能阻塞 API 的接收线程
+	select {
+	case handler.Ch <- streamMessage{table: table, fields: fields}:
+	default:
+		handler.status.messageDropped(1)
 	}
-	handler.Ch <- fields
 }
 
 func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
 
 	log.DefaultLogger.Debug("Run Stream Request")
+	// 写入用的 channel 没有数据源，只需要保持订阅，写入由 PublishStream 处理
+	if strings.HasPrefix(req.Path, publishPathPrefix) {
+		<-ctx.Done()
+		return nil
+	}
 	// Unmarshal the JSON into our queryModel.
 	var qm queryModel
 
 	err := json.Unmarshal(req.Data, &qm)
 	if err != nil {
 		log.DefaultLogger.Error("Streaming request JSON Parse Error")
+		return fmt.Errorf("streaming request json unmarshal: %w", err)
 	}
 
 	// 接下来的是订阅流数据的代码
 	// 流数据订阅的 channel
-	ddbChan := make(chan []*data.Field)
+	ddbChan := make(chan streamMessage, streamBufferSize)
+	status := newStreamStatus()
+	frames := &streamFrameSender{sender: sender, status: status}
+	framename := fmt.Sprintf("Stream %s", qm.RefID)
 
+	// 同一个 channel 的数据推送给所有订阅的用户，所以流数据始终使用数据源配置的用户，不做用户映射
 	config, err := parseJSONData(req.PluginContext.DataSourceInstanceSettings.JSONData)
 	if err != nil {
 		return err
 	}
+	// 插件生成的读取脚本同样经过只读检查
+	readOnly, err := parseReadOnlySettings(req.PluginContext.DataSourceInstanceSettings.JSONData)
+	if err != nil {
+		return err
+	}
 
-	rand.Seed(time.Now().UnixNano())
-
-	// 随机生成 action 数字，用于区分 action
-	randomNumber := rand.Intn(1000000) + 1 + time.Now().Second()
+	uid := req.PluginContext.DataSourceInstanceSettings.UID
 
-	// 将整数转换为字符串
-	randomNumberStr := strconv.Itoa(randomNumber)
+	// 回放模式不需要订阅流数据表
+	if qm.Streaming.Mode == streamingModeReplay {
+		return runReplay(ctx, qm.Streaming.Replay, readOnly, uid, config, db.TransformOptions{ArrayVector: qm.ArrayVector}, framename, frames)
+	}
 
-	// 先获取列名
-	df, err := db.RunSimpleScript(fmt.Sprintf("select top 1 * from %s", qm.Streaming.Table), req.PluginContext.DataSourceInstanceSettings.UID, config)
+	// action 名称由数据源和 channel 确定，发布端残留的订阅能被识别和清理
+	actionName := streamActionName(uid, req.Path)
 
+	opts := db.TransformOptions{ArrayVector: qm.ArrayVector}
+	tables, err := qm.Streaming.validTables()
 	if err != nil {
-		log.DefaultLogger.Error("Error get table structure")
 		return err
 	}
-	tb := df.(*model.Table)
+
+	// 先获取列名
+	schemas := make(map[string]*model.Table, len(tables))
+	for _, table := range tables {
+		script := fmt.Sprintf("select top 1 * from %s", table)
+		if err := readOnly.checkScript(script); err != nil {
+			return err
+		}
+		df, err := db.RunSimpleScript(script, uid, config)
+		if err != nil {
+			log.DefaultLogger.Error("Error get table structure", "table", table)
+			return err
+		}
+		schemas[table] = df.(*model.Table)
+	}
+	tb := schemas[tables[0]]
+
+	// 多表合并模式：按时间列做 as-of 合并，输出一个 frame
+	var merger *asofMerger
+	var mergeTickC <-chan time.Time
+	if len(tables) > 1 && qm.Streaming.Merge != nil {
+		templates := make(map[string][]*data.Field, len(tables))
+		for _, table := range tables {
+			frame, err := db.TransformDataFormWithOptions(schemas[table], table, opts)
+			if err != nil {
+				return err
+			}
+			templates[table] = frame.Fields
+		}
+		merger, err = newAsofMerger(*qm.Streaming.Merge, tables, templates)
+		if err != nil {
+			return err
+		}
+		ticker := time.NewTicker(mergeTick)
+		defer ticker.Stop()
+		mergeTickC = ticker.C
+	}
+
+	// latest 模式：按键保存最新值，定时推送快照
+	var latest *latestValueStore
+	var snapshotTick <-chan time.Time
+	if qm.Streaming.Mode == streamingModeLatest {
+		if len(qm.Streaming.KeyColumns) == 0 {
+			return errors.New("latest streaming mode requires at least one key column")
+		}
+		for _, key := range qm.Streaming.KeyColumns {
+			if !containsString(tb.ColNames, key) {
+				return fmt.Errorf("key column %s does not exist in streaming table %s", key, tables[0])
+			}
+		}
+		latest = newLatestValueStore(qm.Streaming.KeyColumns)
+		interval := time.Duration(qm.Streaming.SnapshotIntervalMs) * time.Millisecond
+		if interval <= 0 {
+			interval = defaultSnapshotInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		snapshotTick = ticker.C
+	}
+
+	// window 模式：在插件内做窗口聚合
+	var window *windowAggregator
+	var windowTick <-chan time.Time
+	if qm.Streaming.Mode == streamingModeWindow {
+		if qm.Streaming.Window == nil {
+			return errors.New("window streaming mode requires a window definition")
+		}
+		if err := qm.Streaming.Window.validate(tb.ColNames); err != nil {
+			return err
+		}
+		window = newWindowAggregator(*qm.Streaming.Window)
+		// 按到达时间聚合时，由定时器推动窗口结束
+		if !window.eventTime() {
+			ticker := time.NewTicker(window.slide)
+			defer ticker.Stop()
+			windowTick = ticker.C
+		}
+	}
+
+	// 同名的订阅如果还留在发布端，说明是之前遗留的，先清理掉再订阅
+	cleanupStreamActions(uid, config, actionName)
+	activeStreamActions.Store(actionName, true)
+	defer activeStreamActions.Delete(actionName)
 
 	client := streaming.NewGoroutineClient("localhost", 8101)
-	// actionName, _ := uuid.NewUUID()
-	// size := 1
-	subscribeReq := &streaming.SubscribeRequest{
-		Address:    config.URL,
-		TableName:  qm.Streaming.Table,
-		ActionName: fmt.Sprintf("action%s", randomNumberStr),
-		Handler:    &ddbStreamingHandler{Ch: ddbChan, tb: tb},
-		Offset:     -1,
-		Reconnect:  true,
-		UserID:     config.Username,
-		Password:   config.Password,
-		// BatchSize:  &size,
-		// MsgAsTable: true,
-	}
-	err = client.Subscribe(subscribeReq)
-	if err != nil {
-		log.DefaultLogger.Error("unable to subscribe streaming table")
-		log.DefaultLogger.Error(fmt.Sprintf("%v", err))
+	// 所有表共用一个 handler，通过消息的 topic 区分来自哪张表
+	handler := &ddbStreamingHandler{
+		Ch:     ddbChan,
+		tables: schemas,
+		status: status,
+		opts:   opts,
+	}
+	var subscribeReqs []*streaming.SubscribeRequest
+	unsubscribeAll := func() {
+		for _, subscribeReq := range subscribeReqs {
+			client.UnSubscribe(subscribeReq)
+		}
+	}
+	for _, table := range tables {
+		// size := 1
+		subscribeReq := &streaming.SubscribeRequest{
+			Address:    config.URL,
+			TableName:  table,
+			ActionName: actionName,
+			Handler:    handler,
+			Offset:     -1,
+			Reconnect:  true,
+			UserID:     config.Username,
+			Password:   config.Password,
+			// BatchSize:  &size,
+			// MsgAsTable: true,
+		}
+		if err := client.Subscribe(subscribeReq); err != nil {
+			// 订阅失败无法恢复，取消已经成功的订阅，结束这个流并把错误返回给面板
+			log.DefaultLogger.Error("unable to subscribe streaming table", "table", table, "error", err)
+			unsubscribeAll()
+			return fmt.Errorf("unable to subscribe streaming table %s: %w", table, err)
+		}
+		subscribeReqs = append(subscribeReqs, subscribeReq)
 	}
 
 	log.DefaultLogger.Info("Subscribe to DB Streaming table complete.")
 
+	go watchSubscriptions(ctx, newSubscriptionMonitor(actionName, tables, status), uid, config)
+	statusTicker := time.NewTicker(streamStatusInterval)
+	defer statusTicker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			// 取消流数据表订阅
 			log.DefaultLogger.Debug("Streaming terminated.")
-			client.UnSubscribe(subscribeReq)
+			unsubscribeAll()
 			return ctx.Err()
-		case chanData := <-ddbChan:
-			// 收到流推送
-			frame := data.NewFrame(
-				fmt.Sprintf("Stream %s", qm.RefID),
-			)
-			frame.Fields = append(frame.Fields, chanData...)
-
-			err := sender.SendFrame(
-				frame,
-				data.IncludeAll,
-			)
-
-			if err != nil {
-				log.DefaultLogger.Error("Failed send frame", "error", err)
+		case <-statusTicker.C:
+			// latest 模式下推送的是全量快照，不能用空的 frame 替换掉
+			if latest != nil {
+				if status.pending() {
+					frames.Send(latest.Snapshot(framename))
+				}
+				continue
+			}
+			frames.Flush(framename)
+		case <-snapshotTick:
+			// 没有更新就不推送
+			if !latest.changed {
+				continue
+			}
+			frames.Send(latest.Snapshot(framename))
+		case now := <-windowTick:
+			frames.Send(window.Advance(now, framename))
+		case now := <-mergeTickC:
+			frames.Send(merger.Flush(now, framename))
+		case msg := <-ddbChan:
+			if latest != nil {
+				latest.Update(msg.fields)
+				continue
+			}
+			if window != nil {
+				window.Add(msg.fields, time.Now())
+				if window.eventTime() {
+					frames.Send(window.Advance(window.watermark, framename))
+				}
+				continue
+			}
+			if merger != nil {
+				if !merger.Add(msg, time.Now()) {
+					status.schemaMismatch(mergedColumnName(msg.table, qm.Streaming.Merge.TimeColumn), "has no value, rows without time cannot be merged")
+					continue
+				}
+				frames.Send(merger.Flush(time.Now(), framename))
+				continue
+			}
+			// 收到流推送，订阅多张表时每张表推送各自的 frame
+			name := framename
+			if len(tables) > 1 {
+				name = fmt.Sprintf("%s %s", framename, msg.table)
 			}
+			frame := data.NewFrame(name)
+			frame.Fields = append(frame.Fields, msg.fields...)
+			frames.Send(frame)
 		}
 	}
 }
@@ -26,3 +26,24 @@ func TestQueryData(t *testing.T) {
 		t.Fatal("QueryData must return a response")
 	}
 }
+
+func TestStreamingValidTables(t *testing.T) {
+	cases := []struct {
+		streaming streamingQueryModel
+		valid     bool
+	}{
+		{streamingQueryModel{Table: "trades"}, true},
+		{streamingQueryModel{Tables: []string{"trades", "quotes"}}, true},
+		// 单表也会拼接到获取列名的脚本中
+		{streamingQueryModel{Table: `trades; dropTable(loadTable("dfs://db", "t"))`}, false},
+		{streamingQueryModel{Table: "trades where 1=1"}, false},
+		{streamingQueryModel{Table: ""}, false},
+		{streamingQueryModel{Tables: []string{"trades", "quotes;undef(`trades)"}}, false},
+		{streamingQueryModel{Tables: []string{"trades", "quotes"}, Mode: streamingModeLatest}, false},
+	}
+	for _, c := range cases {
+		if _, err := c.streaming.validTables(); (err == nil) != c.valid {
+			t.Errorf("%+v: expected valid %v, got %v", c.streaming, c.valid, err)
+		}
+	}
+}
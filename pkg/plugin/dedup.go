@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// 查询结果在 frame 元数据中的去重标记
+const (
+	// 这个查询实际执行了，SharedWith 为共享结果的其他查询数
+	dedupExecuted = "executed"
+	// 和正在执行的相同查询共享了结果，没有再次执行
+	dedupShared = "shared"
+)
+
+// queryMeta 放在 frame 的 Meta.Custom 中，说明查询是怎样得到结果的
+type queryMeta struct {
+	Dedup      string `json:"dedup,omitempty"`
+	SharedWith int    `json:"sharedWith,omitempty"`
+	// 结果缓存是否命中，没有开启缓存时为空
+	Cache string `json:"cache,omitempty"`
+	// 增量查询是查询了完整的时间范围（full）还是只查询了新数据（delta）
+	Incremental string `json:"incremental,omitempty"`
+	// 拆分执行的块数
+	Chunks int `json:"chunks,omitempty"`
+}
+
+// inflightGroup 合并同时执行的相同查询，只执行一次，结果由所有相同的查询共享
+type inflightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+type inflightCall struct {
+	done   chan struct{}
+	result model.DataForm
+	err    error
+	// 加入的其他查询数
+	shared int
+}
+
+func newInflightGroup() *inflightGroup {
+	return &inflightGroup{calls: make(map[string]*inflightCall)}
+}
+
+// join 返回 key 对应的正在执行的查询，leader 为 true 时调用方负责执行并调用 finish
+// g 为 nil 时不去重，每个查询都单独执行
+func (g *inflightGroup) join(key string) (call *inflightCall, leader bool) {
+	if g == nil {
+		return &inflightCall{done: make(chan struct{})}, true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if call, ok := g.calls[key]; ok {
+		call.shared++
+		return call, false
+	}
+	call = &inflightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	return call, true
+}
+
+// finish 保存执行结果，通知等待的查询，返回共享结果的查询数
+func (g *inflightGroup) finish(key string, call *inflightCall, result model.DataForm, err error) int {
+	if g == nil {
+		call.result, call.err = result, err
+		close(call.done)
+		return 0
+	}
+	g.mu.Lock()
+	delete(g.calls, key)
+	call.result = result
+	call.err = err
+	shared := call.shared
+	g.mu.Unlock()
+	close(call.done)
+	return shared
+}
+
+// wait 等待 leader 执行完成
+func (call *inflightCall) wait(ctx context.Context) (model.DataForm, error) {
+	select {
+	case <-call.done:
+		return call.result, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// isCancellation 判断错误是否因为请求被取消或者超时
+// leader 的请求被取消不代表查询本身失败，不能把这个错误返回给共享结果的其他请求
+func isCancellation(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// do 执行 key 对应的查询，相同的查询正在执行时等待并共享它的结果
+// 等待的 leader 因为自己的请求被取消而失败时，重新加入，由其中一个等待的查询重新执行
+func (g *inflightGroup) do(ctx context.Context, key string, run func() (model.DataForm, error)) (model.DataForm, queryMeta, error) {
+	for {
+		call, leader := g.join(key)
+		if leader {
+			result, err := run()
+			shared := g.finish(key, call, result, err)
+			return result, queryMeta{Dedup: dedupExecuted, SharedWith: shared}, err
+		}
+		result, err := call.wait(ctx)
+		if err != nil && isCancellation(err) && ctx.Err() == nil {
+			continue
+		}
+		return result, queryMeta{Dedup: dedupShared}, err
+	}
+}
+
+// inflightKey 由数据源和映射的用户（连接池的 key）、最终执行的脚本或函数调用以及时间范围组成
+func inflightKey(poolKey string, q backend.DataQuery, qm queryModel, script string) string {
+	var sb strings.Builder
+	sb.WriteString(poolKey)
+	sb.WriteByte(0)
+	sb.WriteString(script)
+	sb.WriteByte(0)
+	if q.QueryType == queryTypeFunction {
+		args, _ := json.Marshal(qm.Function.Args)
+		sb.Write(args)
+	}
+	sb.WriteByte(0)
+	fmt.Fprintf(&sb, "%d-%d", q.TimeRange.From.UnixNano(), q.TimeRange.To.UnixNano())
+	return sb.String()
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestInflightGroup(t *testing.T) {
+	g := newInflightGroup()
+	q := backend.DataQuery{TimeRange: backend.TimeRange{From: time.UnixMilli(0), To: time.UnixMilli(1000)}}
+	key := inflightKey("uid", q, queryModel{}, "select * from t")
+
+	leader, isLeader := g.join(key)
+	if !isLeader {
+		t.Fatal("first query should execute")
+	}
+	follower, isLeader := g.join(key)
+	if isLeader || follower != leader {
+		t.Fatal("identical query should join the in-flight one")
+	}
+	// 不同的用户或者时间范围不共享
+	other := inflightKey("uid/alice", q, queryModel{}, "select * from t")
+	if _, isLeader := g.join(other); !isLeader {
+		t.Fatal("queries of different users should not be shared")
+	}
+
+	dt, err := model.NewDataType(model.DtInt, int32(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := model.NewScalar(dt)
+	done := make(chan model.DataForm)
+	go func() {
+		df, _ := follower.wait(context.Background())
+		done <- df
+	}()
+	if shared := g.finish(key, leader, result, nil); shared != 1 {
+		t.Fatalf("expected 1 shared query, got %d", shared)
+	}
+	if df := <-done; df != result {
+		t.Fatal("follower should receive the leader's result")
+	}
+	// 执行完成后相同的查询重新执行
+	if _, isLeader := g.join(key); !isLeader {
+		t.Fatal("finished query should not be shared")
+	}
+}
+
+func TestInflightGroupLeaderCancelled(t *testing.T) {
+	g := newInflightGroup()
+	key := "uid\x00select * from t"
+	dt, err := model.NewDataType(model.DtInt, int32(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := model.NewScalar(dt)
+
+	leader, _ := g.join(key)
+	type outcome struct {
+		df   model.DataForm
+		meta queryMeta
+		err  error
+	}
+	done := make(chan outcome, 2)
+	runs := make(chan struct{}, 2)
+	release := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			df, meta, err := g.do(context.Background(), key, func() (model.DataForm, error) {
+				runs <- struct{}{}
+				<-release
+				return result, nil
+			})
+			done <- outcome{df, meta, err}
+		}()
+	}
+	// waitShared 等待 n 个查询加入 key 对应的正在执行的查询
+	waitShared := func(n int) {
+		for {
+			g.mu.Lock()
+			call := g.calls[key]
+			shared := call != nil && call.shared == n
+			g.mu.Unlock()
+			if shared {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// 等两个查询都加入后，leader 所在的请求被取消，其中一个查询重新执行，另一个等待它的结果
+	waitShared(2)
+	g.finish(key, leader, nil, fmt.Errorf("Error running connection pool tasks: %w", context.Canceled))
+	waitShared(1)
+	close(release)
+
+	executed := 0
+	for i := 0; i < 2; i++ {
+		o := <-done
+		if o.err != nil || o.df != result {
+			t.Fatalf("followers must not receive the leader's cancellation, got %v", o.err)
+		}
+		if o.meta.Dedup == dedupExecuted {
+			executed++
+		}
+	}
+	if executed != 1 || len(runs) != 1 {
+		t.Fatalf("expected one follower to rerun the query, got %d executed, %d runs", executed, len(runs))
+	}
+
+	// 等待的请求自己被取消时直接返回
+	leader, _ = g.join(key)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := g.do(ctx, key, func() (model.DataForm, error) { return result, nil }); err != context.Canceled {
+		t.Fatalf("expected the follower's own cancellation, got %v", err)
+	}
+	g.finish(key, leader, result, nil)
+}
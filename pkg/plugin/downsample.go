@@ -0,0 +1,380 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 降采样算法
+const (
+	// Largest-Triangle-Three-Buckets，保留曲线的形状
+	downsampleLTTB = "lttb"
+	// 每个桶保留最小值和最大值所在的行
+	downsampleMinMax = "minmax"
+	// 每个桶的平均值
+	downsampleAvg = "avg"
+)
+
+// downsampleFrames 把时间序列 frame 中的行数降到查询的 MaxDataPoints 以内，没有开启降采样时原样返回
+// lttb 和 minmax 从原始的行中选择，每个数值列分别选出自己的点，合并后按时间排序，avg 按桶计算平均值
+func downsampleFrames(frames data.Frames, q backend.DataQuery, qm queryModel) (data.Frames, error) {
+	switch qm.Downsample {
+	case "":
+		return frames, nil
+	case downsampleLTTB, downsampleMinMax, downsampleAvg:
+	default:
+		return nil, fmt.Errorf("unknown downsampling algorithm %s", qm.Downsample)
+	}
+	if !isTimeSeriesQuery(q, qm) {
+		return frames, nil
+	}
+	maxPoints := int(q.MaxDataPoints)
+	if maxPoints <= 0 {
+		maxPoints = qm.MaxDataPoints
+	}
+	if maxPoints <= 0 {
+		return frames, nil
+	}
+	out := make(data.Frames, len(frames))
+	for i, frame := range frames {
+		out[i] = downsampleFrame(frame, qm.Downsample, maxPoints)
+	}
+	return out, nil
+}
+
+// downsampleFrame 只处理按时间升序排列的时间序列 frame，其他 frame 原样返回
+// 字符串列作为序列的键，长格式的 frame 中多个序列交错排列，每个序列分别降采样后再按时间合并
+func downsampleFrame(frame *data.Frame, algorithm string, maxPoints int) *data.Frame {
+	rows := frame.Rows()
+	if rows <= maxPoints {
+		return frame
+	}
+	ti := timeFieldIndex(frame)
+	if ti < 0 {
+		return frame
+	}
+	keys := seriesKeyFields(frame, ti)
+	var series [][]int
+	index := make(map[string]int)
+	for row := 0; row < rows; row++ {
+		key := seriesKey(frame, keys, row)
+		si, ok := index[key]
+		if !ok {
+			si = len(series)
+			index[key] = si
+			series = append(series, nil)
+		}
+		series[si] = append(series[si], row)
+	}
+	// 每个序列分到的点数，合并后的行数不超过 maxPoints
+	perSeries := maxPoints / len(series)
+	if perSeries < 3 {
+		return withDownsampleNotice(frame, data.NoticeSeverityWarning, fmt.Sprintf("%d series do not fit into %d points, the result was not downsampled", len(series), maxPoints))
+	}
+
+	var result *data.Frame
+	if len(series) == 1 {
+		var ok bool
+		if result, ok = downsampleSeries(frame, ti, algorithm, perSeries); !ok {
+			return frame
+		}
+	} else {
+		parts := make([]*data.Frame, len(series))
+		for si, seriesRows := range series {
+			sub := frame.EmptyCopy()
+			for _, row := range seriesRows {
+				for i, f := range frame.Fields {
+					sub.Fields[i].Append(f.At(row))
+				}
+			}
+			part, ok := downsampleSeries(sub, ti, algorithm, perSeries)
+			if !ok {
+				return frame
+			}
+			parts[si] = part
+		}
+		result = mergeByTime(frame, parts, ti)
+	}
+	if result.Rows() >= rows {
+		return frame
+	}
+	result.Meta = frame.Meta
+
+	text := fmt.Sprintf("downsampled from %d to %d rows with %s (%.1f:1)",
+		rows, result.Rows(), algorithm, float64(rows)/float64(max(result.Rows(), 1)))
+	if len(series) > 1 {
+		text += fmt.Sprintf(", %d series downsampled separately", len(series))
+	}
+	if algorithm == downsampleAvg {
+		// avg 只能计算数值列，其他列（键列除外）只能取桶中第一行的值
+		var others []string
+		for i, f := range frame.Fields {
+			if i != ti && !f.Type().Numeric() && !slices.Contains(keys, i) {
+				others = append(others, f.Name)
+			}
+		}
+		if len(others) > 0 {
+			text += fmt.Sprintf(", non-numeric columns %s take the value of the first row in each bucket", strings.Join(others, ", "))
+		}
+	}
+	return withDownsampleNotice(result, data.NoticeSeverityInfo, text)
+}
+
+// downsampleSeries 降采样一个序列，时间不是升序或者没有数值列时返回 false
+func downsampleSeries(frame *data.Frame, ti int, algorithm string, maxPoints int) (*data.Frame, bool) {
+	rows := frame.Rows()
+	xs := make([]float64, rows)
+	for row := 0; row < rows; row++ {
+		t, ok := timeAt(frame.Fields[ti], row)
+		if !ok {
+			return nil, false
+		}
+		xs[row] = float64(t.UnixNano())
+		if row > 0 && xs[row] < xs[row-1] {
+			return nil, false
+		}
+	}
+	var numeric []int
+	for i, f := range frame.Fields {
+		if i != ti && f.Type().Numeric() {
+			numeric = append(numeric, i)
+		}
+	}
+	if len(numeric) == 0 {
+		return nil, false
+	}
+	if rows <= maxPoints {
+		return frame, true
+	}
+
+	if algorithm == downsampleAvg {
+		return averageBuckets(frame, numeric, maxPoints), true
+	}
+	// 每个数值列分到的点数，合并后的行数不超过 maxPoints
+	perField := maxPoints / len(numeric)
+	selected := make(map[int]bool)
+	for _, fi := range numeric {
+		var picked []int
+		if algorithm == downsampleLTTB {
+			picked = lttb(xs, frame.Fields[fi], perField)
+		} else {
+			picked = minMaxBuckets(frame.Fields[fi], perField/2)
+		}
+		for _, row := range picked {
+			selected[row] = true
+		}
+	}
+	if len(selected) >= rows {
+		return frame, true
+	}
+	keep := make([]int, 0, len(selected))
+	for row := range selected {
+		keep = append(keep, row)
+	}
+	sort.Ints(keep)
+	result := frame.EmptyCopy()
+	for i, f := range frame.Fields {
+		result.Fields[i].Config = f.Config
+	}
+	for _, row := range keep {
+		for i, f := range frame.Fields {
+			result.Fields[i].Append(f.At(row))
+		}
+	}
+	return result, true
+}
+
+// mergeByTime 把各个序列降采样后的行按时间合并，时间相同时按序列的顺序排列
+func mergeByTime(frame *data.Frame, parts []*data.Frame, ti int) *data.Frame {
+	type ref struct {
+		part int
+		row  int
+		t    time.Time
+	}
+	var refs []ref
+	for pi, part := range parts {
+		for row := 0; row < part.Rows(); row++ {
+			t, _ := timeAt(part.Fields[ti], row)
+			refs = append(refs, ref{part: pi, row: row, t: t})
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].t.Before(refs[j].t) })
+
+	fields := make([]*data.Field, len(frame.Fields))
+	for i := range frame.Fields {
+		f := parts[0].Fields[i]
+		fields[i] = data.NewFieldFromFieldType(f.Type(), 0)
+		fields[i].Name, fields[i].Labels, fields[i].Config = f.Name, f.Labels, f.Config
+	}
+	for _, r := range refs {
+		for i, f := range parts[r.part].Fields {
+			fields[i].Append(f.At(r.row))
+		}
+	}
+	result := data.NewFrame(frame.Name, fields...)
+	result.RefID = frame.RefID
+	return result
+}
+
+// withDownsampleNotice 复制 frame 的元数据并加上一条 notice
+func withDownsampleNotice(frame *data.Frame, severity data.NoticeSeverity, text string) *data.Frame {
+	result := *frame
+	meta := data.FrameMeta{}
+	if frame.Meta != nil {
+		meta = *frame.Meta
+	}
+	meta.Notices = append(append([]data.Notice{}, meta.Notices...), data.Notice{Severity: severity, Text: text})
+	result.Meta = &meta
+	return &result
+}
+
+// point 是一个数值列中不为空的值和所在的行
+type point struct {
+	row int
+	x   float64
+	y   float64
+}
+
+func fieldPoints(xs []float64, f *data.Field) []point {
+	points := make([]point, 0, f.Len())
+	for row := 0; row < f.Len(); row++ {
+		if y, ok := toFloat64(f.At(row)); ok && !math.IsNaN(y) {
+			points = append(points, point{row: row, x: xs[row], y: y})
+		}
+	}
+	return points
+}
+
+// lttb 用 Largest-Triangle-Three-Buckets 选出 threshold 个点，保留首尾两点，返回选中的行
+func lttb(xs []float64, f *data.Field, threshold int) []int {
+	points := fieldPoints(xs, f)
+	if threshold < 3 {
+		threshold = 3
+	}
+	if len(points) <= threshold {
+		rows := make([]int, len(points))
+		for i, p := range points {
+			rows[i] = p.row
+		}
+		return rows
+	}
+
+	rows := make([]int, 0, threshold)
+	rows = append(rows, points[0].row)
+	// 除首尾两点外的点平均分到 threshold-2 个桶中
+	every := float64(len(points)-2) / float64(threshold-2)
+	a := 0
+	for i := 0; i < threshold-2; i++ {
+		// 下一个桶的平均点
+		nextStart := int(float64(i+1)*every) + 1
+		nextEnd := min(int(float64(i+2)*every)+1, len(points))
+		var avgX, avgY float64
+		for _, p := range points[nextStart:nextEnd] {
+			avgX += p.x
+			avgY += p.y
+		}
+		n := float64(nextEnd - nextStart)
+		avgX /= n
+		avgY /= n
+
+		// 当前桶中和上一个选中的点、下一个桶的平均点组成的三角形面积最大的点
+		start := int(float64(i)*every) + 1
+		end := int(float64(i+1)*every) + 1
+		pa := points[a]
+		maxArea, picked := -1.0, start
+		for j := start; j < end; j++ {
+			p := points[j]
+			area := math.Abs((pa.x-avgX)*(p.y-pa.y) - (pa.x-p.x)*(avgY-pa.y))
+			if area > maxArea {
+				maxArea, picked = area, j
+			}
+		}
+		rows = append(rows, points[picked].row)
+		a = picked
+	}
+	return append(rows, points[len(points)-1].row)
+}
+
+// minMaxBuckets 把行平均分到 buckets 个桶中，每个桶保留最小值和最大值所在的行
+func minMaxBuckets(f *data.Field, buckets int) []int {
+	if buckets < 1 {
+		buckets = 1
+	}
+	rows := f.Len()
+	var picked []int
+	for b := 0; b < buckets; b++ {
+		start, end := b*rows/buckets, (b+1)*rows/buckets
+		minRow, maxRow := -1, -1
+		var minY, maxY float64
+		for row := start; row < end; row++ {
+			y, ok := toFloat64(f.At(row))
+			if !ok || math.IsNaN(y) {
+				continue
+			}
+			if minRow < 0 || y < minY {
+				minRow, minY = row, y
+			}
+			if maxRow < 0 || y > maxY {
+				maxRow, maxY = row, y
+			}
+		}
+		if minRow >= 0 {
+			picked = append(picked, minRow, maxRow)
+		}
+	}
+	return picked
+}
+
+// averageBuckets 把行平均分到 buckets 个桶中，时间取桶中第一行的时间，数值列取平均值，其他列取第一行的值，
+// 调用方在 notice 中说明取第一行的值的列
+func averageBuckets(frame *data.Frame, numeric []int, buckets int) *data.Frame {
+	rows := frame.Rows()
+	isNumeric := make(map[int]bool, len(numeric))
+	fields := make([]*data.Field, len(frame.Fields))
+	for i, f := range frame.Fields {
+		if slices.Contains(numeric, i) {
+			isNumeric[i] = true
+			fields[i] = data.NewField(f.Name, f.Labels, make([]*float64, 0, buckets))
+			fields[i].Config = f.Config
+		} else {
+			fields[i] = data.NewFieldFromFieldType(f.Type(), 0)
+			fields[i].Name, fields[i].Labels, fields[i].Config = f.Name, f.Labels, f.Config
+		}
+	}
+	for b := 0; b < buckets; b++ {
+		start, end := b*rows/buckets, (b+1)*rows/buckets
+		if start == end {
+			continue
+		}
+		for i, f := range frame.Fields {
+			if !isNumeric[i] {
+				fields[i].Append(f.At(start))
+				continue
+			}
+			var sum float64
+			n := 0
+			for row := start; row < end; row++ {
+				if y, ok := toFloat64(f.At(row)); ok && !math.IsNaN(y) {
+					sum += y
+					n++
+				}
+			}
+			var avg *float64
+			if n > 0 {
+				v := sum / float64(n)
+				avg = &v
+			}
+			fields[i].Append(avg)
+		}
+	}
+	result := data.NewFrame(frame.Name, fields...)
+	result.RefID = frame.RefID
+	return result
+}
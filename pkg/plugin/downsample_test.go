@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func sineFrame(rows int) *data.Frame {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	times := make([]time.Time, rows)
+	values := make([]*float64, rows)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * time.Second)
+		v := math.Sin(float64(i) / 50)
+		values[i] = &v
+	}
+	// 一个尖峰，降采样后需要保留
+	spike := 10.0
+	values[rows/3] = &spike
+	return data.NewFrame("A", data.NewField("time", nil, times), data.NewField("value", nil, values))
+}
+
+func fieldMax(f *data.Field) float64 {
+	result := math.Inf(-1)
+	for i := 0; i < f.Len(); i++ {
+		if v, ok := toFloat64(f.At(i)); ok && v > result {
+			result = v
+		}
+	}
+	return result
+}
+
+func TestDownsampleFrames(t *testing.T) {
+	q := backend.DataQuery{MaxDataPoints: 100}
+	for _, algorithm := range []string{downsampleLTTB, downsampleMinMax, downsampleAvg} {
+		frames, err := downsampleFrames(data.Frames{sineFrame(10000)}, q, queryModel{Downsample: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := frames[0]
+		if frame.Rows() > 100 || frame.Rows() < 50 {
+			t.Fatalf("%s: unexpected row count %d", algorithm, frame.Rows())
+		}
+		if algorithm != downsampleAvg && fieldMax(frame.Fields[1]) != 10 {
+			t.Fatalf("%s: the spike was dropped", algorithm)
+		}
+		if len(frame.Meta.Notices) != 1 || !strings.Contains(frame.Meta.Notices[0].Text, "from 10000 to") {
+			t.Fatalf("%s: unexpected notices %v", algorithm, frame.Meta.Notices)
+		}
+	}
+
+	frames, _ := downsampleFrames(data.Frames{sineFrame(50)}, q, queryModel{Downsample: downsampleLTTB})
+	if frames[0].Rows() != 50 || frames[0].Meta != nil {
+		t.Fatal("frames within MaxDataPoints should not change")
+	}
+	if _, err := downsampleFrames(data.Frames{sineFrame(50)}, q, queryModel{Downsample: "median"}); err == nil {
+		t.Fatal("expected an error for an unknown algorithm")
+	}
+}
+
+func TestDownsampleLongFrame(t *testing.T) {
+	// 两个 sym 交错排列，a 的值在 0 附近，b 的值在 100 附近
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var times []time.Time
+	var syms []string
+	var values []float64
+	var flags []bool
+	for i := 0; i < 1000; i++ {
+		for j, sym := range []string{"a", "b"} {
+			times = append(times, start.Add(time.Duration(i)*time.Second))
+			syms = append(syms, sym)
+			values = append(values, float64(j*100)+math.Sin(float64(i)/50))
+			flags = append(flags, i%2 == 0)
+		}
+	}
+	frame := data.NewFrame("A",
+		data.NewField("time", nil, times),
+		data.NewField("sym", nil, syms),
+		data.NewField("value", nil, values),
+		data.NewField("flag", nil, flags),
+	)
+
+	q := backend.DataQuery{MaxDataPoints: 100}
+	for _, algorithm := range []string{downsampleLTTB, downsampleMinMax, downsampleAvg} {
+		frames, err := downsampleFrames(data.Frames{frame}, q, queryModel{Downsample: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := frames[0]
+		if result.Rows() > 100 || result.Rows() < 50 {
+			t.Fatalf("%s: unexpected row count %d", algorithm, result.Rows())
+		}
+		counts := map[string]int{}
+		for row := 0; row < result.Rows(); row++ {
+			sym := result.Fields[1].At(row).(string)
+			counts[sym]++
+			// 每个序列的值不会和另一个序列的值混在一起
+			v, _ := toFloat64(result.Fields[2].At(row))
+			if sym == "a" && v > 1 || sym == "b" && v < 99 {
+				t.Fatalf("%s: row %d of %s has value %v from the other series", algorithm, row, sym, v)
+			}
+			if row > 0 && result.Fields[0].At(row).(time.Time).Before(result.Fields[0].At(row-1).(time.Time)) {
+				t.Fatalf("%s: rows are not sorted by time", algorithm)
+			}
+		}
+		if counts["a"] == 0 || counts["a"] != counts["b"] {
+			t.Fatalf("%s: unexpected rows per series %v", algorithm, counts)
+		}
+		notice := result.Meta.Notices[0].Text
+		if !strings.Contains(notice, "2 series") || (algorithm == downsampleAvg) != strings.Contains(notice, "columns flag take the value of the first row") {
+			t.Fatalf("%s: unexpected notice %s", algorithm, notice)
+		}
+	}
+}
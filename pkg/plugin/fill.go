@@ -0,0 +1,294 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 补齐缺失的时间点时填充的值
+const (
+	fillNull     = "null"
+	fillPrevious = "previous"
+	fillZero     = "zero"
+	fillLinear   = "linear"
+	// 填充 Value 指定的常量
+	fillValue = "value"
+)
+
+// 补齐后最多的行数，避免很小的间隔在很长的时间范围上生成过多的行
+const maxFillPoints = 100000
+
+// fillQueryModel 把时间列对齐到查询的间隔，补齐整个时间范围内缺失的时间点
+type fillQueryModel struct {
+	// null、previous、zero、linear 或 value，为空时不补齐
+	Mode  string  `json:"mode,omitempty"`
+	Value float64 `json:"value,omitempty"`
+}
+
+// isTimeSeriesQuery 判断查询的结果是否是用户脚本返回的普通表格，注释、日志和监控查询的结果有固定的结构
+func isTimeSeriesQuery(q backend.DataQuery, qm queryModel) bool {
+	if qm.Format == formatLogs {
+		return false
+	}
+	return q.QueryType == "" || q.QueryType == queryTypeBuilder || q.QueryType == queryTypeFunction
+}
+
+// fillFrames 补齐时间序列 frame 中缺失的时间点，没有开启补齐时原样返回
+func fillFrames(frames data.Frames, q backend.DataQuery, qm queryModel) (data.Frames, error) {
+	switch qm.Fill.Mode {
+	case "":
+		return frames, nil
+	case fillNull, fillPrevious, fillZero, fillLinear, fillValue:
+	default:
+		return nil, fmt.Errorf("unknown fill mode %s", qm.Fill.Mode)
+	}
+	if !isTimeSeriesQuery(q, qm) {
+		return frames, nil
+	}
+	interval := q.Interval
+	if interval <= 0 {
+		interval = time.Duration(qm.IntervalMs) * time.Millisecond
+	}
+	if interval <= 0 {
+		return frames, nil
+	}
+	out := make(data.Frames, len(frames))
+	for i, frame := range frames {
+		out[i] = fillFrame(frame, q.TimeRange, interval, qm.Fill)
+	}
+	return out, nil
+}
+
+// fillFrame 按 interval 把时间范围划分为时间点，每行的时间向下取整到所在的时间点，时间范围之外的行会被去掉
+// 字符串列作为序列的键，长格式的 frame 中每个序列分别补齐，补齐后的行按时间点、再按序列第一次出现的顺序排列
+// 同一个序列的同一个时间点有多行（数据比间隔更密）时不补齐，原样返回并在 notice 中说明
+// 补齐后时间列和键列以外的列都是可以为空的类型
+func fillFrame(frame *data.Frame, tr backend.TimeRange, interval time.Duration, m fillQueryModel) *data.Frame {
+	ti := timeFieldIndex(frame)
+	if ti < 0 {
+		return frame
+	}
+	start := alignTime(tr.From, interval)
+	count := int(tr.To.Sub(start)/interval) + 1
+	if count > maxFillPoints {
+		return withFillNotice(frame, fmt.Sprintf("filling %s with an interval of %s needs %d points, more than %d, gaps were not filled", tr.To.Sub(tr.From), interval, count, maxFillPoints))
+	}
+
+	keys := seriesKeyFields(frame, ti)
+	// 每个序列在每个时间点对应的行，-1 表示缺失，firstRows 为每个序列的第一行，用来填写键列
+	var series [][]int
+	var firstRows []int
+	index := make(map[string]int)
+	newSeries := func(row int) {
+		rows := make([]int, count)
+		for i := range rows {
+			rows[i] = -1
+		}
+		series = append(series, rows)
+		firstRows = append(firstRows, row)
+	}
+	if len(keys) == 0 {
+		// 宽格式的 frame 只有一个序列，没有数据时也补齐整个时间范围
+		newSeries(-1)
+	}
+	for row := 0; row < frame.Rows(); row++ {
+		t, ok := timeAt(frame.Fields[ti], row)
+		if !ok || t.Before(start) || t.After(tr.To) {
+			continue
+		}
+		key := seriesKey(frame, keys, row)
+		si, ok := index[key]
+		if !ok && len(keys) > 0 {
+			si = len(series)
+			index[key] = si
+			newSeries(row)
+		}
+		bucket := int(t.Sub(start) / interval)
+		if series[si][bucket] >= 0 {
+			return withFillNotice(frame, fmt.Sprintf("several rows of the same series fall into one %s interval at %s, aggregate the data by the interval before filling, gaps were not filled", interval, start.Add(time.Duration(bucket)*interval).Format(ddbTimestampLayout)))
+		}
+		series[si][bucket] = row
+	}
+	if count*len(series) > maxFillPoints {
+		return withFillNotice(frame, fmt.Sprintf("filling %d series with an interval of %s needs %d points, more than %d, gaps were not filled", len(series), interval, count*len(series), maxFillPoints))
+	}
+
+	isKey := make(map[int]bool, len(keys))
+	for _, k := range keys {
+		isKey[k] = true
+	}
+	n := len(series)
+	fields := make([]*data.Field, len(frame.Fields))
+	for i, f := range frame.Fields {
+		ft := f.Type()
+		if i != ti && !isKey[i] {
+			ft = ft.NullableType()
+		}
+		out := data.NewFieldFromFieldType(ft, count*n)
+		out.Name, out.Labels, out.Config = f.Name, f.Labels, f.Config
+		for si, rows := range series {
+			switch {
+			case i == ti:
+				for bucket := range rows {
+					out.SetConcrete(bucket*n+si, start.Add(time.Duration(bucket)*interval))
+				}
+			case isKey[i]:
+				for bucket := range rows {
+					out.Set(bucket*n+si, f.CopyAt(firstRows[si]))
+				}
+			default:
+				filled := fillSeries(f, ft, rows, m)
+				for bucket := range rows {
+					out.Set(bucket*n+si, filled.CopyAt(bucket))
+				}
+			}
+		}
+		fields[i] = out
+	}
+
+	result := data.NewFrame(frame.Name, fields...)
+	result.RefID = frame.RefID
+	if frame.Meta != nil {
+		meta := *frame.Meta
+		result.Meta = &meta
+	}
+	return result
+}
+
+// seriesKeyFields 返回作为序列键的字符串列的下标
+func seriesKeyFields(frame *data.Frame, ti int) []int {
+	var keys []int
+	for i, f := range frame.Fields {
+		if i != ti && f.Type().NonNullableType() == data.FieldTypeString {
+			keys = append(keys, i)
+		}
+	}
+	return keys
+}
+
+func seriesKey(frame *data.Frame, keys []int, row int) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		if v, ok := frame.Fields[k].ConcreteAt(row); ok {
+			parts[i] = "v" + v.(string)
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// fillSeries 取出一个序列在每个时间点的值并补齐缺失的时间点
+func fillSeries(f *data.Field, ft data.FieldType, rows []int, m fillQueryModel) *data.Field {
+	out := data.NewFieldFromFieldType(ft, len(rows))
+	for bucket, row := range rows {
+		if row >= 0 {
+			if v, ok := f.ConcreteAt(row); ok {
+				out.SetConcrete(bucket, v)
+			}
+		}
+	}
+	fillGaps(out, rows, m)
+	return out
+}
+
+// withFillNotice 返回原样的 frame，在 notice 中说明没有补齐的原因
+func withFillNotice(frame *data.Frame, text string) *data.Frame {
+	result := *frame
+	meta := data.FrameMeta{}
+	if frame.Meta != nil {
+		meta = *frame.Meta
+	}
+	meta.Notices = append(append([]data.Notice{}, meta.Notices...), data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     text,
+	})
+	result.Meta = &meta
+	return &result
+}
+
+// alignTime 和 DolphinDB 的 bar 函数一样，从 1970.01.01 开始按 interval 向下取整
+func alignTime(t time.Time, interval time.Duration) time.Time {
+	ns := t.UnixNano()
+	offset := ns % int64(interval)
+	if offset < 0 {
+		offset += int64(interval)
+	}
+	return time.Unix(0, ns-offset).In(t.Location())
+}
+
+// fillGaps 填充缺失的时间点，已有的行中的空值保持不变
+// zero、value 和 linear 只填充数值列，previous 填充所有列
+func fillGaps(f *data.Field, rows []int, m fillQueryModel) {
+	numeric := f.Type().Numeric()
+	for bucket, row := range rows {
+		if row >= 0 {
+			continue
+		}
+		switch {
+		case m.Mode == fillPrevious:
+			if bucket > 0 {
+				if v, ok := f.ConcreteAt(bucket - 1); ok {
+					f.SetConcrete(bucket, v)
+				}
+			}
+		case m.Mode == fillZero && numeric:
+			f.SetConcrete(bucket, numericValue(f.Type(), 0))
+		case m.Mode == fillValue && numeric:
+			f.SetConcrete(bucket, numericValue(f.Type(), m.Value))
+		case m.Mode == fillLinear && numeric:
+			if v, ok := interpolate(f, rows, bucket); ok {
+				f.SetConcrete(bucket, numericValue(f.Type(), v))
+			}
+		}
+	}
+}
+
+// interpolate 用前后最近的有值的时间点线性插值，时间范围两端的缺失点没有插值
+func interpolate(f *data.Field, rows []int, bucket int) (float64, bool) {
+	prev, next := -1, -1
+	var prevValue, nextValue float64
+	for i := bucket - 1; i >= 0; i-- {
+		if v, ok := toFloat64(f.At(i)); ok && rows[i] >= 0 {
+			prev, prevValue = i, v
+			break
+		}
+	}
+	for i := bucket + 1; i < len(rows); i++ {
+		if v, ok := toFloat64(f.At(i)); ok && rows[i] >= 0 {
+			next, nextValue = i, v
+			break
+		}
+	}
+	if prev < 0 || next < 0 {
+		return 0, false
+	}
+	return prevValue + (nextValue-prevValue)*float64(bucket-prev)/float64(next-prev), true
+}
+
+// numericValue 把 float64 转换为数值列的类型
+func numericValue(ft data.FieldType, v float64) interface{} {
+	switch ft.NonNullableType() {
+	case data.FieldTypeInt8:
+		return int8(v)
+	case data.FieldTypeInt16:
+		return int16(v)
+	case data.FieldTypeInt32:
+		return int32(v)
+	case data.FieldTypeInt64:
+		return int64(v)
+	case data.FieldTypeUint8:
+		return uint8(v)
+	case data.FieldTypeUint16:
+		return uint16(v)
+	case data.FieldTypeUint32:
+		return uint32(v)
+	case data.FieldTypeUint64:
+		return uint64(v)
+	case data.FieldTypeFloat32:
+		return float32(v)
+	}
+	return v
+}
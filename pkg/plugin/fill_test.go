@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestFillFrames(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	frame := data.NewFrame("A",
+		data.NewField("time", nil, []time.Time{start.Add(time.Minute), start.Add(4 * time.Minute)}),
+		data.NewField("value", nil, []int64{10, 40}),
+		data.NewField("flag", nil, []bool{true, false}),
+	)
+	q := backend.DataQuery{
+		Interval:  time.Minute,
+		TimeRange: backend.TimeRange{From: start.Add(30 * time.Second), To: start.Add(5*time.Minute + 30*time.Second)},
+	}
+
+	cases := []struct {
+		fill  fillQueryModel
+		value []interface{}
+		flag  []interface{}
+	}{
+		{fillQueryModel{Mode: fillNull}, []interface{}{nil, 10, nil, nil, 40, nil}, []interface{}{nil, true, nil, nil, false, nil}},
+		{fillQueryModel{Mode: fillPrevious}, []interface{}{nil, 10, 10, 10, 40, 40}, []interface{}{nil, true, true, true, false, false}},
+		{fillQueryModel{Mode: fillZero}, []interface{}{0, 10, 0, 0, 40, 0}, []interface{}{nil, true, nil, nil, false, nil}},
+		{fillQueryModel{Mode: fillLinear}, []interface{}{nil, 10, 20, 30, 40, nil}, []interface{}{nil, true, nil, nil, false, nil}},
+		{fillQueryModel{Mode: fillValue, Value: -1}, []interface{}{-1, 10, -1, -1, 40, -1}, []interface{}{nil, true, nil, nil, false, nil}},
+	}
+	for _, c := range cases {
+		frames, err := fillFrames(data.Frames{frame}, q, queryModel{Fill: c.fill})
+		if err != nil {
+			t.Fatal(err)
+		}
+		filled := frames[0]
+		if filled.Rows() != 6 || !filled.Fields[0].At(0).(time.Time).Equal(start) {
+			t.Fatalf("%s: unexpected time field %v", c.fill.Mode, filled.Fields[0])
+		}
+		for row := 0; row < 6; row++ {
+			value, ok := filled.Fields[1].ConcreteAt(row)
+			if !ok {
+				value = nil
+			}
+			if c.value[row] == nil && value != nil || c.value[row] != nil && value != int64(c.value[row].(int)) {
+				t.Fatalf("%s: value at row %d is %v, expected %v", c.fill.Mode, row, value, c.value[row])
+			}
+			flag, ok := filled.Fields[2].ConcreteAt(row)
+			if !ok {
+				flag = nil
+			}
+			if flag != c.flag[row] {
+				t.Fatalf("%s: flag at row %d is %v, expected %v", c.fill.Mode, row, flag, c.flag[row])
+			}
+		}
+	}
+
+	if _, err := fillFrames(data.Frames{frame}, q, queryModel{Fill: fillQueryModel{Mode: "spline"}}); err == nil {
+		t.Fatal("expected an error for an unknown fill mode")
+	}
+}
+
+func TestFillLongFrame(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := backend.DataQuery{Interval: time.Minute, TimeRange: backend.TimeRange{From: start, To: start.Add(2 * time.Minute)}}
+	m := queryModel{Fill: fillQueryModel{Mode: fillPrevious}}
+
+	// 长格式的 frame，每个时间点有多个 sym，每个 sym 分别补齐
+	frame := data.NewFrame("A",
+		data.NewField("time", nil, []time.Time{start, start, start.Add(2 * time.Minute)}),
+		data.NewField("sym", nil, []string{"a", "b", "a"}),
+		data.NewField("value", nil, []float64{1, 2, 3}),
+	)
+	frames, err := fillFrames(data.Frames{frame}, q, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filled := frames[0]
+	syms := []string{"a", "b", "a", "b", "a", "b"}
+	values := []float64{1, 2, 1, 2, 3, 2}
+	if filled.Rows() != len(syms) {
+		t.Fatalf("expected %d rows, got %d", len(syms), filled.Rows())
+	}
+	for row := range syms {
+		if filled.Fields[1].At(row).(string) != syms[row] {
+			t.Fatalf("sym at row %d is %v, expected %s", row, filled.Fields[1].At(row), syms[row])
+		}
+		if v, _ := filled.Fields[2].ConcreteAt(row); v != values[row] {
+			t.Fatalf("value at row %d is %v, expected %v", row, v, values[row])
+		}
+		if !filled.Fields[0].At(row).(time.Time).Equal(start.Add(time.Duration(row/2) * time.Minute)) {
+			t.Fatalf("unexpected time at row %d: %v", row, filled.Fields[0].At(row))
+		}
+	}
+
+	// 数据比间隔更密时不合并行，原样返回
+	fine := data.NewFrame("A",
+		data.NewField("time", nil, []time.Time{start, start.Add(10 * time.Second)}),
+		data.NewField("value", nil, []float64{1, 2}),
+	)
+	frames, _ = fillFrames(data.Frames{fine}, q, m)
+	if frames[0].Rows() != 2 || len(frames[0].Meta.Notices) != 1 {
+		t.Fatalf("expected the frame to be returned unchanged with a notice, got %v", frames[0])
+	}
+}
+
+func TestFillLocalTime(t *testing.T) {
+	loc, err := queryLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DolphinDB 返回的是 UTC+8 的墙上时间，面板时间范围为 UTC 的 00:00 到 00:02
+	instant := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	local := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	q := backend.DataQuery{Interval: time.Minute, TimeRange: ddbTimeRange(backend.TimeRange{From: instant, To: instant.Add(2 * time.Minute)}, loc)}
+	frame := data.NewFrame("A",
+		data.NewField("time", nil, []time.Time{local, local.Add(2 * time.Minute)}),
+		data.NewField("value", nil, []float64{1, 3}),
+	)
+	frames, err := fillFrames(data.Frames{frame}, q, queryModel{Fill: fillQueryModel{Mode: fillLinear}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := frames[0].Fields[1].ConcreteAt(1); frames[0].Rows() != 3 || v != 2.0 {
+		t.Fatalf("unexpected filled frame %v", frames[0])
+	}
+}
@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/dolphindb/api-go/v3/api"
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// 函数调用的 QueryType，通过 API 的函数调用接口执行服务端函数或函数视图，参数按类型传递，不拼接脚本
+const queryTypeFunction = "function"
+
+// 参数值的来源
+const (
+	argSourceValue      = "value"
+	argSourceTimeFrom   = "timeFrom"
+	argSourceTimeTo     = "timeTo"
+	argSourceInterval   = "interval"
+	argSourceIntervalMs = "intervalMs"
+)
+
+// 参数的形式
+const (
+	argFormScalar = "scalar"
+	argFormVector = "vector"
+)
+
+// 函数名，函数视图可以带模块名，例如 mod::func
+var functionNameRegexp = regexp.MustCompile(`^[A-Za-z_]\w*(::[A-Za-z_]\w*)*$`)
+
+type functionCallModel struct {
+	Name string             `json:"name"`
+	Args []functionArgModel `json:"args,omitempty"`
+}
+
+type functionArgModel struct {
+	// 参数值的来源，value（默认）为 Value 中的值，其他的由查询的时间范围和间隔得到
+	Source string `json:"source,omitempty"`
+	// DolphinDB 的类型名，例如 INT、SYMBOL、TIMESTAMP、DECIMAL64(2)
+	Type string `json:"type"`
+	// scalar（默认）或 vector，vector 时 Value 为数组
+	Form  string      `json:"form,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// functionTask 生成函数调用的任务，Args 不为 nil 时连接池通过 RunFunc 执行
+// 时间参数和脚本中的时间字面量一样是面板时区 loc 的墙上时间，q 的时间范围已经由 ddbTimeRange 转换
+func functionTask(q backend.DataQuery, fn functionCallModel, loc *time.Location) (*api.Task, error) {
+	if !functionNameRegexp.MatchString(fn.Name) {
+		return nil, fmt.Errorf("invalid function name %q", fn.Name)
+	}
+	args := make([]model.DataForm, 0, len(fn.Args))
+	for i, arg := range fn.Args {
+		df, err := functionArg(arg, q.TimeRange, q.Interval, loc)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		args = append(args, df)
+	}
+	return &api.Task{Script: fn.Name, Args: args}, nil
+}
+
+// functionArg 把一个参数转换为对应类型的标量或者向量
+func functionArg(arg functionArgModel, timeRange backend.TimeRange, interval time.Duration, loc *time.Location) (model.DataForm, error) {
+	typeName := arg.Type
+	var value interface{}
+	switch arg.Source {
+	case "", argSourceValue:
+		value = arg.Value
+	case argSourceTimeFrom:
+		value = timeRange.From
+	case argSourceTimeTo:
+		value = timeRange.To
+	case argSourceInterval:
+		// API 的 duration 只支持单个字母的单位，不足一秒的按一秒处理
+		seconds := int64(interval / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		if typeName == "" {
+			typeName = "DURATION"
+		}
+		value = fmt.Sprintf("%ds", seconds)
+	case argSourceIntervalMs:
+		if typeName == "" {
+			typeName = "LONG"
+		}
+		value = interval.Milliseconds()
+	default:
+		return nil, fmt.Errorf("unknown argument source %s", arg.Source)
+	}
+	if typeName == "" {
+		switch arg.Source {
+		case argSourceTimeFrom, argSourceTimeTo:
+			typeName = "TIMESTAMP"
+		default:
+			return nil, errors.New("argument type is required")
+		}
+	}
+
+	ct, err := db.ParseTypeName(typeName)
+	if err != nil {
+		return nil, err
+	}
+	temporal := db.IsTemporal(ct)
+	switch arg.Form {
+	case "", argFormScalar:
+		if temporal {
+			value = localTimeArg(value, loc)
+		}
+		return db.NewScalarFromValue(ct, value)
+	case argFormVector:
+		values, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("vector argument expects an array, got %v", value)
+		}
+		if temporal {
+			localized := make([]interface{}, len(values))
+			for i, v := range values {
+				localized[i] = localTimeArg(v, loc)
+			}
+			values = localized
+		}
+		return db.NewVectorFromValues(ct, values)
+	}
+	return nil, fmt.Errorf("unknown argument form %s", arg.Form)
+}
+
+// localTimeArg 把表示时刻的参数值（例如 ${__from} 展开的 Unix 毫秒、带时区的 RFC3339 字符串）转换为 loc 中的墙上时间，
+// DolphinDB 格式的时间字符串和时间范围已经是墙上时间，原样返回
+func localTimeArg(v interface{}, loc *time.Location) interface{} {
+	switch t := v.(type) {
+	case string:
+		if parsed, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return ddbTime(parsed, loc)
+		}
+		return t
+	case float64:
+		if t == math.Trunc(t) {
+			return ddbTime(time.UnixMilli(int64(t)), loc)
+		}
+	case json.Number:
+		if ms, err := t.Int64(); err == nil {
+			return ddbTime(time.UnixMilli(ms), loc)
+		}
+	case int64:
+		return ddbTime(time.UnixMilli(t), loc)
+	}
+	return v
+}
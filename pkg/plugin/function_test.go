@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestFunctionTask(t *testing.T) {
+	q := backend.DataQuery{
+		TimeRange: backend.TimeRange{
+			From: time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC),
+			To:   time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+		},
+		Interval: 500 * time.Millisecond,
+	}
+	task, err := functionTask(q, functionCallModel{
+		Name: "views::getBars",
+		Args: []functionArgModel{
+			{Type: "SYMBOL", Form: argFormVector, Value: []interface{}{"AAPL", "MSFT"}},
+			{Source: argSourceTimeFrom},
+			{Source: argSourceInterval},
+			{Type: "INT", Value: float64(10)},
+		},
+	}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Script != "views::getBars" || len(task.Args) != 4 {
+		t.Fatalf("unexpected task %+v", task)
+	}
+	if vct, ok := task.Args[0].(*model.Vector); !ok || vct.Rows() != 2 || vct.GetDataType() != model.DtSymbol {
+		t.Fatalf("unexpected vector argument %v", task.Args[0])
+	}
+	if task.Args[1].GetDataType() != model.DtTimestamp || task.Args[2].GetDataType() != model.DtDuration {
+		t.Fatalf("unexpected argument types %s %s", task.Args[1].GetDataTypeString(), task.Args[2].GetDataTypeString())
+	}
+
+	// 没有参数时 Args 也不能为 nil，否则连接池会把函数名当作脚本执行
+	task, err = functionTask(q, functionCallModel{Name: "now"}, time.UTC)
+	if err != nil || task.Args == nil {
+		t.Fatalf("expected empty args, got %v %v", task, err)
+	}
+	if _, err := functionTask(q, functionCallModel{Name: "f(1); dropDatabase"}, time.UTC); err == nil {
+		t.Fatal("expected invalid function name error")
+	}
+	if _, err := functionTask(q, functionCallModel{Name: "f", Args: []functionArgModel{{Type: "INT", Value: "x"}}}, time.UTC); err == nil {
+		t.Fatal("expected invalid argument error")
+	}
+}
+
+func TestFunctionTaskLocalTime(t *testing.T) {
+	loc, err := queryLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 面板时区 UTC+8 的 09:30，和脚本查询中 $timeFilter 展开的时间相同
+	instant := time.Date(2024, 1, 2, 1, 30, 0, 0, time.UTC)
+	q := backend.DataQuery{TimeRange: ddbTimeRange(backend.TimeRange{From: instant, To: instant.Add(time.Hour)}, loc)}
+	task, err := functionTask(q, functionCallModel{
+		Name: "views::getBars",
+		Args: []functionArgModel{
+			{Source: argSourceTimeFrom},
+			// ${__from} 展开的 Unix 毫秒
+			{Type: "TIMESTAMP", Value: float64(instant.UnixMilli())},
+			{Type: "TIMESTAMP", Value: "2024-01-02T01:30:00Z"},
+			{Type: "TIMESTAMP", Value: "2024.01.02 09:30:00.000"},
+		},
+	}, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, arg := range task.Args {
+		if got := arg.String(); got != "timestamp(2024.01.02T09:30:00.000)" {
+			t.Fatalf("argument %d is %s", i+1, got)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// 映射的 DolphinDB 用户的密码保存在 secureJsonData 中，键为 userPassword.<username>
+const userPasswordKeyPrefix = "userPassword."
+
+// Grafana 用户的匹配方式
+// 需求中的按团队匹配没有实现：Grafana 传给数据源插件的 PluginContext.User 中没有团队信息，
+// 查询团队需要插件持有调用 Grafana API 的服务账号，所以按组分配身份时使用 Grafana 角色（Viewer、Editor、Admin）
+const (
+	userMatchLogin = "login"
+	userMatchEmail = "email"
+	userMatchRole  = "role"
+)
+
+// userMappingModel 把一个 Grafana 用户（或者一个角色的所有用户）映射到一个 DolphinDB 用户
+type userMappingModel struct {
+	// login（默认）、email 或 role
+	Match string `json:"match,omitempty"`
+	Value string `json:"value"`
+	// DolphinDB 用户名
+	Username string `json:"username"`
+}
+
+type identitySettingsModel struct {
+	// 按顺序匹配，第一条匹配的映射生效
+	UserMapping []userMappingModel `json:"userMapping,omitempty"`
+	// 没有匹配的映射时拒绝查询，为 false 时使用数据源配置的用户
+	RequireUserMapping bool `json:"requireUserMapping,omitempty"`
+}
+
+func parseIdentitySettings(jsonData json.RawMessage) (identitySettingsModel, error) {
+	var settings identitySettingsModel
+	if err := json.Unmarshal(jsonData, &settings); err != nil {
+		return settings, err
+	}
+	// 不支持的匹配方式直接报错，避免映射被静默忽略后使用数据源配置的用户
+	for _, m := range settings.UserMapping {
+		switch m.Match {
+		case "", userMatchLogin, userMatchEmail, userMatchRole:
+		case "team":
+			return settings, errors.New("user mapping by team is not supported because Grafana does not pass team membership to data source plugins, map by role instead")
+		default:
+			return settings, fmt.Errorf("unknown user mapping match %s, expected login, email or role", m.Match)
+		}
+	}
+	return settings, nil
+}
+
+func (m userMappingModel) matches(user *backend.User) bool {
+	switch m.Match {
+	case "", userMatchLogin:
+		return user.Login != "" && user.Login == m.Value
+	case userMatchEmail:
+		return user.Email != "" && strings.EqualFold(user.Email, m.Value)
+	case userMatchRole:
+		return user.Role != "" && user.Role == m.Value
+	}
+	return false
+}
+
+// datasourceConfig 解析数据源配置，并把发起请求的 Grafana 用户映射为 DolphinDB 的用户
+// 返回的 key 用于区分连接池，不同的 DolphinDB 用户使用各自的连接池
+func datasourceConfig(pCtx backend.PluginContext) (string, db.DBConfig, error) {
+	settings := pCtx.DataSourceInstanceSettings
+	if settings == nil {
+		return "", db.DBConfig{}, errors.New("missing datasource instance settings")
+	}
+	config, err := parseJSONData(settings.JSONData)
+	if err != nil {
+		return "", config, err
+	}
+	identity, err := parseIdentitySettings(settings.JSONData)
+	if err != nil {
+		return "", config, err
+	}
+	return resolveIdentity(identity, pCtx.User, settings.UID, config, settings.DecryptedSecureJSONData)
+}
+
+func resolveIdentity(identity identitySettingsModel, user *backend.User, uid string, config db.DBConfig, secure map[string]string) (string, db.DBConfig, error) {
+	if len(identity.UserMapping) == 0 {
+		return uid, config, nil
+	}
+	if user != nil {
+		for _, m := range identity.UserMapping {
+			if !m.matches(user) {
+				continue
+			}
+			password, ok := secure[userPasswordKeyPrefix+m.Username]
+			if m.Username == "" || !ok {
+				return "", config, fmt.Errorf("no password is configured for DolphinDB user %s", m.Username)
+			}
+			config.Username = m.Username
+			config.Password = password
+			return uid + "/" + m.Username, config, nil
+		}
+	}
+	if identity.RequireUserMapping {
+		login := ""
+		if user != nil {
+			login = user.Login
+		}
+		return "", config, fmt.Errorf("no DolphinDB user is mapped to Grafana user %s", login)
+	}
+	return uid, config, nil
+}
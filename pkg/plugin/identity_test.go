@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestResolveIdentity(t *testing.T) {
+	config := db.DBConfig{URL: "127.0.0.1:8848", Username: "admin", Password: "123456"}
+	identity := identitySettingsModel{UserMapping: []userMappingModel{
+		{Value: "alice", Username: "analyst_alice"},
+		{Match: userMatchEmail, Value: "Bob@example.com", Username: "analyst_bob"},
+		{Match: userMatchRole, Value: "Viewer", Username: "viewer"},
+	}}
+	secure := map[string]string{
+		"userPassword.analyst_alice": "a",
+		"userPassword.analyst_bob":   "b",
+	}
+
+	key, got, err := resolveIdentity(identity, &backend.User{Login: "alice", Role: "Viewer"}, "uid", config, secure)
+	if err != nil || key != "uid/analyst_alice" || got.Username != "analyst_alice" || got.Password != "a" || got.URL != config.URL {
+		t.Fatalf("unexpected identity %s %+v %v", key, got, err)
+	}
+	key, got, _ = resolveIdentity(identity, &backend.User{Login: "bob", Email: "bob@example.com"}, "uid", config, secure)
+	if key != "uid/analyst_bob" || got.Username != "analyst_bob" {
+		t.Fatalf("unexpected identity %s %+v", key, got)
+	}
+	// 映射的用户没有配置密码
+	if _, _, err := resolveIdentity(identity, &backend.User{Login: "carol", Role: "Viewer"}, "uid", config, secure); err == nil {
+		t.Fatal("expected missing password error")
+	}
+	// 没有匹配时使用数据源配置的用户，或者在要求映射时拒绝
+	key, got, err = resolveIdentity(identity, &backend.User{Login: "dave", Role: "Editor"}, "uid", config, secure)
+	if err != nil || key != "uid" || got != config {
+		t.Fatalf("unexpected fallback %s %+v %v", key, got, err)
+	}
+	identity.RequireUserMapping = true
+	if _, _, err := resolveIdentity(identity, &backend.User{Login: "dave", Role: "Editor"}, "uid", config, secure); err == nil {
+		t.Fatal("expected unmapped user error")
+	}
+}
+
+func TestParseIdentitySettings(t *testing.T) {
+	settings, err := parseIdentitySettings([]byte(`{"userMapping": [{"value": "alice", "username": "a"}, {"match": "role", "value": "Editor", "username": "e"}]}`))
+	if err != nil || len(settings.UserMapping) != 2 {
+		t.Fatalf("unexpected settings %+v %v", settings, err)
+	}
+	// 团队和未知的匹配方式不能被静默忽略
+	for _, match := range []string{"team", "group"} {
+		if _, err := parseIdentitySettings([]byte(`{"userMapping": [{"match": "` + match + `", "value": "x", "username": "x"}]}`)); err == nil {
+			t.Errorf("expected an error for match %s", match)
+		}
+	}
+}
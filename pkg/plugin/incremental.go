@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 增量查询默认和上次的结果重叠的时间，覆盖迟到的数据
+const defaultIncrementalOverlap = time.Minute
+
+// 最多保存的增量查询状态数，超过时淘汰最久没有更新的
+const maxIncrementalStates = 1000
+
+// 查询结果在 frame 元数据中的增量标记
+const (
+	incrementalFull  = "full"
+	incrementalDelta = "delta"
+)
+
+// incrementalQueryModel 开启增量查询，脚本中需要用 $__timeFilter 等宏引用时间范围，由后端按新的时间窗口展开
+type incrementalQueryModel struct {
+	Enabled bool `json:"enabled"`
+	// 和上次的结果重叠的秒数，默认 60
+	OverlapSeconds *int `json:"overlapSeconds,omitempty"`
+}
+
+func (m incrementalQueryModel) overlap() time.Duration {
+	if m.OverlapSeconds == nil {
+		return defaultIncrementalOverlap
+	}
+	if *m.OverlapSeconds < 0 {
+		return 0
+	}
+	return time.Duration(*m.OverlapSeconds) * time.Second
+}
+
+// incrementalState 是一个查询上次返回的结果和对应的时间范围
+type incrementalState struct {
+	frame   *data.Frame
+	from    time.Time
+	to      time.Time
+	updated time.Time
+}
+
+type incrementalStore struct {
+	mu     sync.Mutex
+	states map[string]*incrementalState
+}
+
+func newIncrementalStore() *incrementalStore {
+	return &incrementalStore{states: make(map[string]*incrementalState)}
+}
+
+func (s *incrementalStore) get(key string) *incrementalState {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[key]
+}
+
+func (s *incrementalStore) put(key string, state *incrementalState) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[key] = state
+	for len(s.states) > maxIncrementalStates {
+		var oldestKey string
+		var oldest time.Time
+		for k, st := range s.states {
+			if oldestKey == "" || st.updated.Before(oldest) {
+				oldestKey, oldest = k, st.updated
+			}
+		}
+		delete(s.states, oldestKey)
+	}
+}
+
+func (s *incrementalStore) remove(key string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+}
+
+// incrementalKey 区分不同的增量查询，脚本为展开宏之前的脚本
+// 时间范围和结果中的时间都是面板时区的墙上时间，面板时区不同的结果不能合并
+func incrementalKey(poolKey string, q backend.DataQuery, qm queryModel) string {
+	return strings.Join([]string{poolKey, q.RefID, qm.QueryText, qm.ArrayVector, qm.Format, qm.Timezone}, "\x00")
+}
+
+// rangeQueryRunner 用给定的时间范围执行查询，返回转换后的 frame，增量查询和拆分查询都通过它执行
+type rangeQueryRunner func(ctx context.Context, q backend.DataQuery) (data.Frames, error)
+
+// queryIncremental 只查询上次结果之后的新数据，和上次的结果合并，并去掉时间范围之外的行
+// q 的时间范围需要先用 ddbTimeRange 转换，才能和结果中 DolphinDB 的时间比较
+// 没有上次的结果、时间范围不连续或者结果的结构变化时查询完整的时间范围
+func queryIncremental(ctx context.Context, store *incrementalStore, key string, q backend.DataQuery, m incrementalQueryModel, run rangeQueryRunner, now time.Time) (data.Frames, string, error) {
+	from, to := q.TimeRange.From, q.TimeRange.To
+	state := store.get(key)
+	if state != nil && !from.Before(state.from) && !to.Before(state.to) {
+		windowStart := state.to.Add(-m.overlap())
+		// 聚合查询按时间分桶，新窗口从桶的边界开始，第一个桶才是完整的
+		if q.Interval > 0 {
+			windowStart = alignTime(windowStart, q.Interval)
+		}
+		if windowStart.After(from) {
+			delta := q
+			delta.TimeRange.From = windowStart
+			frames, err := run(ctx, delta)
+			if err != nil {
+				return nil, "", err
+			}
+			// 桶比 q.Interval 大时（例如 bar(time, 5m)），第一个桶的时间早于 windowStart，只聚合了一部分数据，从这个桶的开始重新查询
+			if first, ok := firstTime(frames); ok && first.Before(windowStart) && first.After(from) {
+				windowStart = first
+				delta.TimeRange.From = windowStart
+				if frames, err = run(ctx, delta); err != nil {
+					return nil, "", err
+				}
+			}
+			if merged := mergeIncremental(state.frame, frames, from, windowStart); merged != nil {
+				store.put(key, &incrementalState{frame: merged, from: from, to: to, updated: now})
+				return copyFrames(data.Frames{merged}), incrementalDelta, nil
+			}
+		}
+	}
+
+	frames, err := run(ctx, q)
+	if err != nil {
+		store.remove(key)
+		return nil, "", err
+	}
+	if len(frames) == 1 && timeFieldIndex(frames[0]) >= 0 {
+		store.put(key, &incrementalState{frame: frames[0], from: from, to: to, updated: now})
+		frames = copyFrames(frames)
+	} else {
+		// 只有单个时间序列 frame 的结果可以增量合并
+		store.remove(key)
+	}
+	return frames, incrementalFull, nil
+}
+
+// mergeIncremental 合并上次的结果中 [from, windowStart) 的行和新查询的结果，结构不同时返回 nil
+// 新查询的结果中最早的时间早于 windowStart 时（聚合查询的桶），上次的结果只保留这个时间之前的行，避免出现重复的时间
+func mergeIncremental(prev *data.Frame, frames data.Frames, from time.Time, windowStart time.Time) *data.Frame {
+	if len(frames) != 1 || !sameSchema(prev, frames[0]) {
+		return nil
+	}
+	delta := frames[0]
+	ti := timeFieldIndex(prev)
+	if ti < 0 {
+		return nil
+	}
+	if first, ok := firstTime(frames); ok && first.Before(windowStart) {
+		windowStart = first
+	}
+
+	merged := delta.EmptyCopy()
+	merged.Meta = delta.Meta
+	appendRows := func(src *data.Frame, keep func(t time.Time) bool) {
+		for row := 0; row < src.Rows(); row++ {
+			t, ok := timeAt(src.Fields[ti], row)
+			if !ok || !keep(t) {
+				continue
+			}
+			for i, f := range src.Fields {
+				merged.Fields[i].Append(f.At(row))
+			}
+		}
+	}
+	appendRows(prev, func(t time.Time) bool { return !t.Before(from) && t.Before(windowStart) })
+	appendRows(delta, func(t time.Time) bool { return !t.Before(from) })
+	return merged
+}
+
+// firstTime 返回单个 frame 的结果中最早的时间
+func firstTime(frames data.Frames) (time.Time, bool) {
+	if len(frames) != 1 {
+		return time.Time{}, false
+	}
+	ti := timeFieldIndex(frames[0])
+	if ti < 0 {
+		return time.Time{}, false
+	}
+	var first time.Time
+	found := false
+	for row := 0; row < frames[0].Rows(); row++ {
+		if t, ok := timeAt(frames[0].Fields[ti], row); ok && (!found || t.Before(first)) {
+			first, found = t, true
+		}
+	}
+	return first, found
+}
+
+func sameSchema(a *data.Frame, b *data.Frame) bool {
+	if a == nil || b == nil || len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if a.Fields[i].Name != b.Fields[i].Name || a.Fields[i].Type() != b.Fields[i].Type() {
+			return false
+		}
+	}
+	return true
+}
+
+// timeFieldIndex 返回第一个时间列的下标，没有时返回 -1
+func timeFieldIndex(frame *data.Frame) int {
+	for i, f := range frame.Fields {
+		if f.Type().Time() {
+			return i
+		}
+	}
+	return -1
+}
+
+func timeAt(f *data.Field, row int) (time.Time, bool) {
+	switch v := f.At(row).(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v != nil {
+			return *v, true
+		}
+	}
+	return time.Time{}, false
+}
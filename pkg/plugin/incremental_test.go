@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestQueryIncremental(t *testing.T) {
+	base := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	// 模拟每分钟一个点的数据，值为分钟数
+	var queried []backend.TimeRange
+	run := func(_ context.Context, q backend.DataQuery) (data.Frames, error) {
+		queried = append(queried, q.TimeRange)
+		times := []time.Time{}
+		values := []float64{}
+		for ts := q.TimeRange.From.Truncate(time.Minute); !ts.After(q.TimeRange.To); ts = ts.Add(time.Minute) {
+			if ts.Before(q.TimeRange.From) {
+				continue
+			}
+			times = append(times, ts)
+			values = append(values, ts.Sub(base).Minutes())
+		}
+		return data.Frames{data.NewFrame("A", data.NewField("time", nil, times), data.NewField("value", nil, values))}, nil
+	}
+
+	store := newIncrementalStore()
+	overlap := 120
+	m := incrementalQueryModel{Enabled: true, OverlapSeconds: &overlap}
+	query := func(from time.Duration, to time.Duration) backend.DataQuery {
+		return backend.DataQuery{RefID: "A", TimeRange: backend.TimeRange{From: base.Add(from), To: base.Add(to)}}
+	}
+
+	frames, mode, err := queryIncremental(context.Background(), store, "k", query(0, time.Hour), m, run, base)
+	if err != nil || mode != incrementalFull || frames[0].Rows() != 61 {
+		t.Fatalf("unexpected full query %v %s %v", frames, mode, err)
+	}
+
+	// 时间范围向后移动 5 分钟，只查询最后 7 分钟（包括 2 分钟的重叠）
+	frames, mode, err = queryIncremental(context.Background(), store, "k", query(5*time.Minute, 65*time.Minute), m, run, base)
+	if err != nil || mode != incrementalDelta {
+		t.Fatalf("unexpected incremental query %s %v", mode, err)
+	}
+	last := queried[len(queried)-1]
+	if !last.From.Equal(base.Add(58*time.Minute)) || !last.To.Equal(base.Add(65*time.Minute)) {
+		t.Fatalf("unexpected delta window %v", last)
+	}
+	frame := frames[0]
+	if frame.Rows() != 61 {
+		t.Fatalf("expected 61 rows, got %d", frame.Rows())
+	}
+	for i := 0; i < frame.Rows(); i++ {
+		if v := frame.Fields[1].At(i).(float64); v != float64(i+5) {
+			t.Fatalf("row %d: expected %d, got %v", i, i+5, v)
+		}
+	}
+
+	// 时间范围向前移动时重新查询完整的时间范围
+	_, mode, _ = queryIncremental(context.Background(), store, "k", query(0, time.Hour), m, run, base)
+	if mode != incrementalFull {
+		t.Fatalf("expected full query, got %s", mode)
+	}
+}
+
+func TestQueryIncrementalLocalTime(t *testing.T) {
+	loc, err := queryLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// DolphinDB 中的时间是 UTC+8 的墙上时间，每分钟一个点，值为当地时间的分钟数
+	local := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	run := func(_ context.Context, q backend.DataQuery) (data.Frames, error) {
+		times := []time.Time{}
+		values := []float64{}
+		for ts := q.TimeRange.From.Truncate(time.Minute); !ts.After(q.TimeRange.To); ts = ts.Add(time.Minute) {
+			if !ts.Before(q.TimeRange.From) {
+				times = append(times, ts)
+				values = append(values, ts.Sub(local).Minutes())
+			}
+		}
+		return data.Frames{data.NewFrame("A", data.NewField("time", nil, times), data.NewField("value", nil, values))}, nil
+	}
+
+	// 面板时间范围是真实的时刻，当地时间 08:00 为 UTC 00:00
+	instant := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	query := func(from time.Duration, to time.Duration) backend.DataQuery {
+		tr := backend.TimeRange{From: instant.Add(from), To: instant.Add(to)}
+		return backend.DataQuery{RefID: "A", TimeRange: ddbTimeRange(tr, loc)}
+	}
+	store := newIncrementalStore()
+	m := incrementalQueryModel{Enabled: true}
+	if _, _, err := queryIncremental(context.Background(), store, "k", query(0, time.Hour), m, run, instant); err != nil {
+		t.Fatal(err)
+	}
+	frames, mode, err := queryIncremental(context.Background(), store, "k", query(10*time.Minute, 70*time.Minute), m, run, instant)
+	if err != nil || mode != incrementalDelta {
+		t.Fatalf("unexpected incremental query %s %v", mode, err)
+	}
+	frame := frames[0]
+	if frame.Rows() != 61 {
+		t.Fatalf("expected 61 rows, got %d", frame.Rows())
+	}
+	for i := 0; i < frame.Rows(); i++ {
+		if v := frame.Fields[1].At(i).(float64); v != float64(i+10) {
+			t.Fatalf("row %d: expected %d, got %v", i, i+10, v)
+		}
+	}
+
+	q := backend.DataQuery{RefID: "A"}
+	if incrementalKey("ds", q, queryModel{Timezone: "UTC"}) == incrementalKey("ds", q, queryModel{Timezone: "Asia/Shanghai"}) {
+		t.Fatal("expected different keys for different timezones")
+	}
+}
+
+func TestQueryIncrementalBuckets(t *testing.T) {
+	base := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	// 模拟 select count(*) from t where $__timeFilter group by bar(time, 5m)，每分钟一行数据
+	bucket := 5 * time.Minute
+	var queried []backend.TimeRange
+	run := func(_ context.Context, q backend.DataQuery) (data.Frames, error) {
+		queried = append(queried, q.TimeRange)
+		counts := map[time.Time]float64{}
+		times := []time.Time{}
+		for ts := q.TimeRange.From.Truncate(time.Minute); !ts.After(q.TimeRange.To); ts = ts.Add(time.Minute) {
+			if ts.Before(q.TimeRange.From) {
+				continue
+			}
+			b := alignTime(ts, bucket)
+			if _, ok := counts[b]; !ok {
+				times = append(times, b)
+			}
+			counts[b]++
+		}
+		values := make([]float64, len(times))
+		for i, b := range times {
+			values[i] = counts[b]
+		}
+		return data.Frames{data.NewFrame("A", data.NewField("time", nil, times), data.NewField("count", nil, values))}, nil
+	}
+
+	for _, interval := range []time.Duration{bucket, time.Minute} {
+		store := newIncrementalStore()
+		queried = nil
+		m := incrementalQueryModel{Enabled: true}
+		query := func(from time.Duration, to time.Duration) backend.DataQuery {
+			return backend.DataQuery{RefID: "A", Interval: interval, TimeRange: backend.TimeRange{From: base.Add(from), To: base.Add(to)}}
+		}
+		if _, _, err := queryIncremental(context.Background(), store, "k", query(0, time.Hour), m, run, base); err != nil {
+			t.Fatal(err)
+		}
+		// 重叠 1 分钟后新窗口从 00:59:30 开始，落在 00:55 的桶中间
+		frames, mode, err := queryIncremental(context.Background(), store, "k", query(90*time.Second, 61*time.Minute+30*time.Second), m, run, base)
+		if err != nil || mode != incrementalDelta {
+			t.Fatalf("interval %v: unexpected incremental query %s %v", interval, mode, err)
+		}
+		if last := queried[len(queried)-1]; !last.From.Equal(base.Add(55 * time.Minute)) {
+			t.Fatalf("interval %v: expected the delta to start at the 00:55 bucket, got %v", interval, last.From)
+		}
+
+		frame := frames[0]
+		seen := map[time.Time]bool{}
+		for i := 0; i < frame.Rows(); i++ {
+			ts := frame.Fields[0].At(i).(time.Time)
+			if seen[ts] {
+				t.Fatalf("interval %v: duplicate bucket %v", interval, ts)
+			}
+			seen[ts] = true
+			// 除了还没有结束的最后一个桶，其他的桶都是完整的
+			if count := frame.Fields[1].At(i).(float64); i < frame.Rows()-1 && count != 5 {
+				t.Fatalf("interval %v: bucket %v has a partial count %v", interval, ts, count)
+			}
+		}
+		// 00:05 到 01:00 的桶，00:00 的桶的时间早于时间范围的开始
+		if frame.Rows() != 12 {
+			t.Fatalf("interval %v: expected 12 buckets, got %d", interval, frame.Rows())
+		}
+	}
+}
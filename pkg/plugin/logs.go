@@ -0,0 +1,263 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 查询结果的格式
+const (
+	formatTable = "table"
+	formatLogs  = "logs"
+)
+
+// 日志相关的 QueryType，由 Explore 的日志量直方图和日志上下文设置
+const (
+	queryTypeLogsVolume  = "logsVolume"
+	queryTypeLogsContext = "logsContext"
+)
+
+const (
+	// 日志上下文默认返回的行数
+	defaultLogsContextLimit = 10
+	// 没有查询间隔时，日志量直方图的桶数
+	defaultLogsVolumeBuckets = 100
+)
+
+// 没有指定时按这些列名识别日志正文和级别，按顺序优先
+var (
+	logsBodyNames  = []string{"message", "msg", "body", "line", "log", "content", "text"}
+	logsLevelNames = []string{"level", "severity", "lvl", "loglevel", "log_level"}
+)
+
+// 只有一条 select 语句的脚本才能作为子查询生成日志量查询
+var singleSelectRegexp = regexp.MustCompile(`(?is)^\s*select\b[^;]*;?\s*$`)
+
+type logsQueryModel struct {
+	// 日志所在的表，日志量和日志上下文查询需要用到，为空时日志量查询把原查询作为子查询
+	Database string `json:"database,omitempty"`
+	Table    string `json:"table,omitempty"`
+	// 各列的列名，为空时自动识别
+	TimeColumn   string   `json:"timeColumn,omitempty"`
+	BodyColumn   string   `json:"bodyColumn,omitempty"`
+	LevelColumn  string   `json:"levelColumn,omitempty"`
+	LabelColumns []string `json:"labelColumns,omitempty"`
+	// 日志上下文查询的参数
+	Context *logsContextModel `json:"context,omitempty"`
+}
+
+type logsContextModel struct {
+	// 当前日志行的时间，Unix 毫秒
+	Time int64 `json:"time"`
+	// BACKWARD 为更早的日志，FORWARD 为更晚的日志
+	Direction string `json:"direction,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+// logsVolumeScript 生成按时间和级别统计日志条数的查询，timeRange 为 ddbTimeRange 转换后的面板时区的墙上时间
+func logsVolumeScript(m logsQueryModel, script string, timeRange backend.TimeRange, interval time.Duration) (string, error) {
+	if !isValidIdentifier(m.TimeColumn) {
+		return "", errors.New("log volume requires a valid time column")
+	}
+	if m.LevelColumn != "" && !isValidIdentifier(m.LevelColumn) {
+		return "", fmt.Errorf("level column %s is not a valid identifier", m.LevelColumn)
+	}
+
+	var source string
+	switch {
+	case m.Table != "":
+		if !isValidIdentifier(m.Table) {
+			return "", fmt.Errorf("log table %s is not a valid identifier", m.Table)
+		}
+		source = tableRef(m.Database, m.Table)
+	case singleSelectRegexp.MatchString(script):
+		source = fmt.Sprintf("(%s)", strings.TrimSuffix(strings.TrimSpace(script), ";"))
+	default:
+		return "", errors.New("log volume requires a log table or a query with a single select statement")
+	}
+
+	if interval <= 0 {
+		interval = timeRange.Duration() / defaultLogsVolumeBuckets
+	}
+	groupBy := fmt.Sprintf("bar(%s, %s) as bucket", m.TimeColumn, formatDuration(interval))
+	if m.LevelColumn != "" {
+		groupBy += fmt.Sprintf(", %s as level", m.LevelColumn)
+	}
+	return fmt.Sprintf(
+		"select count(*) as count from %s where %s between pair(%s, %s) group by %s order by bucket",
+		source, m.TimeColumn,
+		formatDDBTime(timeRange.From), formatDDBTime(timeRange.To),
+		groupBy,
+	), nil
+}
+
+// logsContextScript 生成查询某一行日志前后若干行的查询
+// 日志行的时间是前端按面板时区转换后的时刻，按 loc 转换回 DolphinDB 中的墙上时间
+func logsContextScript(m logsQueryModel, loc *time.Location) (string, error) {
+	if m.Context == nil {
+		return "", errors.New("log context query requires a context")
+	}
+	if !isValidIdentifier(m.Table) || !isValidIdentifier(m.TimeColumn) {
+		return "", errors.New("log context requires a valid log table and time column")
+	}
+	limit := m.Context.Limit
+	if limit <= 0 {
+		limit = defaultLogsContextLimit
+	}
+	at := formatDDBTime(ddbTime(time.UnixMilli(m.Context.Time), loc))
+	if strings.EqualFold(m.Context.Direction, "forward") {
+		return fmt.Sprintf("select top %d * from %s where %s > %s order by %s asc",
+			limit, tableRef(m.Database, m.Table), m.TimeColumn, at, m.TimeColumn), nil
+	}
+	return fmt.Sprintf("select top %d * from %s where %s < %s order by %s desc",
+		limit, tableRef(m.Database, m.Table), m.TimeColumn, at, m.TimeColumn), nil
+}
+
+// toLogsFrame 把查询结果标记为日志，时间列放在第一列，正文放在第二列，级别列命名为 level
+func toLogsFrame(frame *data.Frame, m logsQueryModel) (*data.Frame, error) {
+	timeField := findField(frame, m.TimeColumn)
+	if m.TimeColumn == "" {
+		timeField = firstFieldOfType(frame, nil, func(f *data.Field) bool { return f.Type().Time() })
+	}
+	if timeField == nil || !timeField.Type().Time() {
+		return nil, errors.New("logs format requires a time column")
+	}
+
+	used := []*data.Field{timeField}
+	levelField := findField(frame, m.LevelColumn)
+	if m.LevelColumn == "" {
+		levelField = findFieldByNames(frame, logsLevelNames)
+	}
+	if levelField != nil {
+		used = append(used, levelField)
+	}
+	bodyField := findField(frame, m.BodyColumn)
+	if m.BodyColumn == "" {
+		bodyField = findFieldByNames(frame, logsBodyNames)
+		if bodyField == nil {
+			bodyField = firstFieldOfType(frame, used, isStringField)
+		}
+	}
+	if bodyField == nil {
+		return nil, errors.New("logs format requires a body column")
+	}
+	used = append(used, bodyField)
+
+	out := data.NewFrame(frame.Name, timeField, bodyField)
+	if levelField != nil {
+		level := data.NewFieldFromFieldType(data.FieldTypeNullableString, levelField.Len())
+		level.Name = "level"
+		for i := 0; i < levelField.Len(); i++ {
+			if !isNilValue(levelField.At(i)) {
+				level.Set(i, stringPtr(strings.ToLower(formatFieldValue(levelField.At(i)))))
+			}
+		}
+		out.Fields = append(out.Fields, level)
+	}
+
+	// 标签列放在最后，没有指定时使用剩下的字符串列，其他列作为日志的字段
+	if len(m.LabelColumns) > 0 {
+		for _, name := range m.LabelColumns {
+			if f := findField(frame, name); f != nil && !containsFieldPtr(used, f) {
+				out.Fields = append(out.Fields, f)
+				used = append(used, f)
+			}
+		}
+	}
+	for _, f := range frame.Fields {
+		if !containsFieldPtr(used, f) {
+			out.Fields = append(out.Fields, f)
+		}
+	}
+
+	out.Meta = frame.Meta
+	if out.Meta == nil {
+		out.Meta = &data.FrameMeta{}
+	}
+	out.Meta.PreferredVisualization = data.VisTypeLogs
+	return out, nil
+}
+
+// toLogsVolumeFrames 把日志量查询的结果按级别拆成多个时间序列
+func toLogsVolumeFrames(frame *data.Frame, refID string) ([]*data.Frame, error) {
+	bucket := findField(frame, "bucket")
+	count := findField(frame, "count")
+	if bucket == nil || count == nil {
+		return nil, errors.New("unexpected log volume result")
+	}
+	level := findField(frame, "level")
+
+	frames := make(map[string]*data.Frame)
+	var levels []string
+	for i := 0; i < bucket.Len(); i++ {
+		t, ok := toTime(bucket.At(i))
+		if !ok {
+			continue
+		}
+		n, _ := toFloat64(count.At(i))
+		name := "logs"
+		if level != nil {
+			name = strings.ToLower(formatFieldValue(level.At(i)))
+		}
+		out, ok := frames[name]
+		if !ok {
+			out = data.NewFrame(refID,
+				data.NewField("time", nil, []time.Time{}),
+				data.NewField("count", data.Labels{"level": name}, []float64{}),
+			)
+			out.Fields[1].Config = &data.FieldConfig{DisplayNameFromDS: name}
+			frames[name] = out
+			levels = append(levels, name)
+		}
+		out.AppendRow(t, n)
+	}
+
+	sort.Strings(levels)
+	result := make([]*data.Frame, 0, len(levels))
+	for _, name := range levels {
+		result = append(result, frames[name])
+	}
+	return result, nil
+}
+
+func findFieldByNames(frame *data.Frame, names []string) *data.Field {
+	for _, name := range names {
+		if f := findField(frame, name); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func firstFieldOfType(frame *data.Frame, exclude []*data.Field, match func(f *data.Field) bool) *data.Field {
+	for _, f := range frame.Fields {
+		if match(f) && !containsFieldPtr(exclude, f) {
+			return f
+		}
+	}
+	return nil
+}
+
+func isStringField(f *data.Field) bool {
+	return f.Type() == data.FieldTypeString || f.Type() == data.FieldTypeNullableString
+}
+
+func containsFieldPtr(fields []*data.Field, target *data.Field) bool {
+	for _, f := range fields {
+		if f == target {
+			return true
+		}
+	}
+	return false
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
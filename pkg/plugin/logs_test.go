@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestToLogsFrame(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	host, level, msg := "node1", "ERROR", "disk full"
+	frame := data.NewFrame("A",
+		data.NewField("host", nil, []*string{&host}),
+		data.NewField("severity", nil, []*string{&level}),
+		data.NewField("msg", nil, []*string{&msg}),
+		data.NewField("ts", nil, []*time.Time{&ts}),
+	)
+
+	out, err := toLogsFrame(frame, logsQueryModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Meta.PreferredVisualization != data.VisTypeLogs {
+		t.Fatal("logs frames must prefer the logs visualisation")
+	}
+	names := make([]string, len(out.Fields))
+	for i, f := range out.Fields {
+		names[i] = f.Name
+	}
+	if got := strings.Join(names, ","); got != "ts,msg,level,host" {
+		t.Fatalf("unexpected field order %s", got)
+	}
+	if v := out.Fields[2].At(0).(*string); *v != "error" {
+		t.Fatalf("unexpected level %s", *v)
+	}
+}
+
+func TestLogsVolumeScript(t *testing.T) {
+	timeRange := backend.TimeRange{
+		From: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC),
+	}
+	m := logsQueryModel{TimeColumn: "ts", LevelColumn: "severity"}
+	got, err := logsVolumeScript(m, "select * from logs where host = `node1;", timeRange, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "select count(*) as count from (select * from logs where host = `node1) where ts between pair(2024.01.02T00:00:00.000, 2024.01.02T01:00:00.000) group by bar(ts, 1m) as bucket, severity as level order by bucket"
+	if got != want {
+		t.Fatalf("unexpected script\n%s", got)
+	}
+	if _, err := logsVolumeScript(m, "t = select * from logs; t", timeRange, time.Minute); err == nil {
+		t.Fatal("expected an error for a multi-statement script without a log table")
+	}
+}
+
+func TestLogsContextScript(t *testing.T) {
+	loc, err := queryLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 前端显示的日志行时间为 UTC+8 的 09:30，对应的时刻是 UTC 的 01:30
+	row := time.Date(2024, 1, 2, 1, 30, 0, 0, time.UTC).UnixMilli()
+	m := logsQueryModel{Table: "logs", TimeColumn: "ts", Context: &logsContextModel{Time: row, Direction: "forward", Limit: 5}}
+	got, err := logsContextScript(m, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "select top 5 * from logs where ts > 2024.01.02T09:30:00.000 order by ts asc"; got != want {
+		t.Fatalf("unexpected script\n%s", got)
+	}
+}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// 和前端 datasource.ts 中的宏保持一致，前端已经展开过的脚本中不会再有这些宏
+// 告警、增量和拆分等前端没有展开的查询由后端展开，时间范围已经由 ddbTimeRange 转换为面板时区的墙上时间
+var (
+	timeFilterMacro = regexp.MustCompile(`\$(__)?timeFilter\b`)
+	timeFromMacro   = regexp.MustCompile(`\$__timeFrom\b`)
+	timeToMacro     = regexp.MustCompile(`\$__timeTo\b`)
+	intervalMacro   = regexp.MustCompile(`\$__interval\b`)
+)
+
+// expandMacros 用查询的时间范围和间隔展开脚本中的宏
+func expandMacros(script string, timeRange backend.TimeRange, interval time.Duration) string {
+	from := formatDDBTime(timeRange.From)
+	to := formatDDBTime(timeRange.To)
+	script = timeFilterMacro.ReplaceAllLiteralString(script, fmt.Sprintf("pair(%s, %s)", from, to))
+	script = timeFromMacro.ReplaceAllLiteralString(script, from)
+	script = timeToMacro.ReplaceAllLiteralString(script, to)
+	script = intervalMacro.ReplaceAllLiteralString(script, formatDuration(interval))
+	return script
+}
+
+// formatDDBTime 把时间格式化为 DolphinDB 的 TIMESTAMP 字面量，不是整毫秒的时间格式化为 NANOTIMESTAMP 字面量
+func formatDDBTime(t time.Time) string {
+	if t.Nanosecond()%int(time.Millisecond) != 0 {
+		return t.Format(ddbNanotimestampLayout)
+	}
+	return t.Format(ddbTimestampLayout)
+}
+
+// formatDuration 把间隔转换为 DolphinDB 的 duration 字面量，例如 1m、2H、500ms
+func formatDuration(d time.Duration) string {
+	units := []struct {
+		unit string
+		size time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"H", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+		{"ms", time.Millisecond},
+	}
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	for _, u := range units {
+		if d%u.size == 0 {
+			return fmt.Sprintf("%d%s", d/u.size, u.unit)
+		}
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
@@ -0,0 +1,23 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestExpandMacros(t *testing.T) {
+	timeRange := backend.TimeRange{
+		From: time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+	}
+	got := expandMacros("select * from t where ts between $__timeFilter group by bar(ts, $__interval), $timeFilter", timeRange, 2*time.Hour)
+	want := "select * from t where ts between pair(2024.01.02T09:30:00.000, 2024.01.02T10:00:00.000) group by bar(ts, 2H), pair(2024.01.02T09:30:00.000, 2024.01.02T10:00:00.000)"
+	if got != want {
+		t.Fatalf("unexpected script\n%s", got)
+	}
+	if got := formatDuration(1500 * time.Millisecond); got != "1500ms" {
+		t.Fatalf("unexpected duration %s", got)
+	}
+}
@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 集群监控的 QueryType，直接调用 DolphinDB 的监控函数，不需要填写脚本
+const (
+	queryTypeClusterPerf      = "clusterPerf"
+	queryTypeStreamingStat    = "streamingStat"
+	queryTypeRecentJobs       = "recentJobs"
+	queryTypeStreamEngineStat = "streamEngineStat"
+)
+
+const (
+	defaultRecentJobsLimit   = 100
+	defaultStreamingStatPart = "subWorkers"
+	defaultStreamEngineType  = "TimeSeriesEngine"
+)
+
+// getStreamingStat 返回的字典中的表
+var streamingStatParts = []string{"pubConns", "subConns", "persistWorkers", "subWorkers", "pubTables"}
+
+type monitorQueryModel struct {
+	// streamingStat 为字典中的表名，例如 subWorkers；streamEngineStat 为引擎类型，例如 TimeSeriesEngine
+	Section string `json:"section,omitempty"`
+	// recentJobs 返回的作业数
+	Limit int `json:"limit,omitempty"`
+}
+
+func isMonitorQueryType(queryType string) bool {
+	switch queryType {
+	case queryTypeClusterPerf, queryTypeStreamingStat, queryTypeRecentJobs, queryTypeStreamEngineStat:
+		return true
+	}
+	return false
+}
+
+// monitorScript 生成监控查询的脚本
+func monitorScript(queryType string, m monitorQueryModel) (string, error) {
+	switch queryType {
+	case queryTypeClusterPerf:
+		return "getClusterPerf()", nil
+	case queryTypeStreamingStat:
+		section := m.Section
+		if section == "" {
+			section = defaultStreamingStatPart
+		}
+		if !containsString(streamingStatParts, section) {
+			return "", fmt.Errorf("unknown streaming stat %s, expected one of %s", section, strings.Join(streamingStatParts, ", "))
+		}
+		return fmt.Sprintf("getStreamingStat().%s", section), nil
+	case queryTypeRecentJobs:
+		limit := m.Limit
+		if limit <= 0 {
+			limit = defaultRecentJobsLimit
+		}
+		return fmt.Sprintf("getRecentJobs(%d)", limit), nil
+	case queryTypeStreamEngineStat:
+		section := m.Section
+		if section == "" {
+			section = defaultStreamEngineType
+		}
+		if !isValidIdentifier(section) {
+			return "", fmt.Errorf("invalid stream engine type %s", section)
+		}
+		// 没有这种引擎时字典中没有这个键，返回只有 name 列的空表
+		return fmt.Sprintf(
+			"stat = getStreamEngineStat()[%s]\nif (isVoid(stat) or isNull(stat)) stat = table(1:0, [`name], [STRING])\nstat",
+			strconv.Quote(section),
+		), nil
+	}
+	return "", fmt.Errorf("unknown monitor query type %s", queryType)
+}
+
+// monitorUnit 描述一列的单位，scale 为转换到这个单位需要乘的系数，0 表示不转换
+type monitorUnit struct {
+	unit  string
+	scale float64
+}
+
+// 监控函数返回的列的单位，统一为字节、字节每秒、毫秒和百分比
+var monitorUnits = map[string]monitorUnit{
+	"cpuUsage":              {unit: "percent"},
+	"maxMemSize":            {unit: "bytes", scale: 1 << 30},
+	"diskFreeSpaceRatio":    {unit: "percentunit"},
+	"diskCapacity":          {unit: "bytes"},
+	"diskFreeSpace":         {unit: "bytes"},
+	"lastMinuteWriteVolume": {unit: "bytes"},
+	"lastMinuteReadVolume":  {unit: "bytes"},
+	"lastMinuteNetworkSend": {unit: "bytes"},
+	"lastMinuteNetworkRecv": {unit: "bytes"},
+	"medLast10QueryTime":    {unit: "ms", scale: 1e-6},
+	"maxLast10QueryTime":    {unit: "ms", scale: 1e-6},
+	"medLast100QueryTime":   {unit: "ms", scale: 1e-6},
+	"maxLast100QueryTime":   {unit: "ms", scale: 1e-6},
+	"maxRunningQueryTime":   {unit: "ms", scale: 1e-6},
+	"throttle":              {unit: "ms"},
+}
+
+// unitOf 返回一列的单位，没有单独定义的列按列名推断
+func unitOf(name string) (monitorUnit, bool) {
+	if u, ok := monitorUnits[name]; ok {
+		return u, true
+	}
+	lower := strings.ToLower(name)
+	switch {
+	case strings.Contains(lower, "memory"):
+		return monitorUnit{unit: "bytes"}, true
+	case strings.HasSuffix(lower, "rate"):
+		return monitorUnit{unit: "Bps"}, true
+	}
+	return monitorUnit{}, false
+}
+
+// normalizeMonitorFrame 统一监控查询结果的单位
+// 快照类的结果在第一列加上查询时间，作业列表加上以毫秒为单位的执行时长
+// now 和查询结果中的时间一样是面板时区的墙上时间（见 ddbTime），前端会按面板时区转换
+func normalizeMonitorFrame(queryType string, frame *data.Frame, now time.Time) *data.Frame {
+	for i, f := range frame.Fields {
+		u, ok := unitOf(f.Name)
+		if !ok {
+			continue
+		}
+		if u.scale != 0 {
+			if scaled := scaleField(f, u.scale); scaled != nil {
+				f = scaled
+				frame.Fields[i] = f
+			}
+		}
+		if f.Config == nil {
+			f.Config = &data.FieldConfig{}
+		}
+		f.Config.Unit = u.unit
+	}
+
+	if queryType == queryTypeRecentJobs {
+		if duration := jobDurations(frame); duration != nil {
+			frame.Fields = append(frame.Fields, duration)
+		}
+		return frame
+	}
+
+	times := make([]time.Time, frame.Rows())
+	for i := range times {
+		times[i] = now
+	}
+	frame.Fields = append([]*data.Field{data.NewField("time", nil, times)}, frame.Fields...)
+	return frame
+}
+
+// scaleField 把数值列乘以 scale，转换为 float64，不是数值的列返回 nil
+func scaleField(f *data.Field, scale float64) *data.Field {
+	if !f.Type().Numeric() {
+		return nil
+	}
+	values := make([]*float64, f.Len())
+	for i := 0; i < f.Len(); i++ {
+		if v, ok := toFloat64(f.At(i)); ok {
+			scaled := v * scale
+			values[i] = &scaled
+		}
+	}
+	out := data.NewField(f.Name, f.Labels, values)
+	out.Config = f.Config
+	return out
+}
+
+// jobDurations 由作业的开始和结束时间计算执行时长
+func jobDurations(frame *data.Frame) *data.Field {
+	start := findField(frame, "startTime")
+	end := findField(frame, "endTime")
+	if start == nil || end == nil {
+		return nil
+	}
+	values := make([]*float64, frame.Rows())
+	for i := range values {
+		s, ok1 := toTime(start.At(i))
+		e, ok2 := toTime(end.At(i))
+		if ok1 && ok2 && !s.IsZero() && !e.IsZero() {
+			ms := float64(e.Sub(s)) / float64(time.Millisecond)
+			values[i] = &ms
+		}
+	}
+	field := data.NewField("durationMs", nil, values)
+	field.Config = &data.FieldConfig{Unit: "ms"}
+	return field
+}
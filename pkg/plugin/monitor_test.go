@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestNormalizeMonitorFrame(t *testing.T) {
+	name := "node1"
+	maxMem := 8.0
+	queryTime := int64(2_500_000)
+	frame := data.NewFrame("A",
+		data.NewField("name", nil, []*string{&name}),
+		data.NewField("maxMemSize", nil, []*float64{&maxMem}),
+		data.NewField("maxLast10QueryTime", nil, []*int64{&queryTime}),
+	)
+
+	now := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	out := normalizeMonitorFrame(queryTypeClusterPerf, frame, now)
+	if out.Fields[0].Name != "time" || out.Fields[0].At(0).(time.Time) != now {
+		t.Fatal("snapshots must start with the query time")
+	}
+	mem, _ := out.FieldByName("maxMemSize")
+	if v := mem.At(0).(*float64); *v != 8*(1<<30) || mem.Config.Unit != "bytes" {
+		t.Fatalf("unexpected memory %v %s", *v, mem.Config.Unit)
+	}
+	qt, _ := out.FieldByName("maxLast10QueryTime")
+	if v := qt.At(0).(*float64); *v != 2.5 || qt.Config.Unit != "ms" {
+		t.Fatalf("unexpected query time %v %s", *v, qt.Config.Unit)
+	}
+
+	if _, err := monitorScript(queryTypeStreamingStat, monitorQueryModel{Section: "drop table"}); err == nil {
+		t.Fatal("expected an error for an unknown streaming stat")
+	}
+}
+
+func TestMonitorSnapshotLocalTime(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "node1"
+	frame := data.NewFrame("A", data.NewField("name", nil, []*string{&name}))
+
+	before := ddbTime(time.Now(), loc)
+	frames, err := postProcess(frame, backend.DataQuery{QueryType: queryTypeClusterPerf}, queryModel{Timezone: "Asia/Shanghai"})
+	after := ddbTime(time.Now(), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 快照时间和其他查询结果一样使用面板时区的墙上时间，前端转换后才是真实的查询时刻
+	got := frames[0].Fields[0].At(0).(time.Time)
+	if got.Before(before) || got.After(after) {
+		t.Fatalf("expected a Shanghai wall-clock time between %v and %v, got %v", before, after, got)
+	}
+}
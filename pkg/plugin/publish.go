@@ -0,0 +1,216 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/dolphindb/api-go/v3/api"
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// 写入数据的 channel 路径前缀，完整的 channel 为 ds/<uid>/publish/<name>
+const publishPathPrefix = "publish/"
+
+// 没有配置 roles 时只允许管理员写入
+var defaultWritableRoles = []string{"Admin"}
+
+// writableTableModel 是数据源配置中允许通过 Grafana Live 写入的表
+type writableTableModel struct {
+	// channel 名称，对应 publish/<name>
+	Name string `json:"name"`
+	// 分布式数据库路径，为空时 Table 为共享的流表或内存表
+	Database string `json:"database,omitempty"`
+	Table    string `json:"table"`
+	// 允许写入的 Grafana 角色，例如 Admin、Editor
+	Roles []string `json:"roles,omitempty"`
+}
+
+type publishSettingsModel struct {
+	WritableTables []writableTableModel `json:"writableTables"`
+}
+
+// 数据源配置中的写入白名单不能放进 db.DBConfig，因为 DBConfig 需要作为连接池的 key 比较
+func parsePublishSettings(jsonData json.RawMessage) (publishSettingsModel, error) {
+	var settings publishSettingsModel
+	err := json.Unmarshal(jsonData, &settings)
+	return settings, err
+}
+
+func (s publishSettingsModel) find(path string) *writableTableModel {
+	if !strings.HasPrefix(path, publishPathPrefix) {
+		return nil
+	}
+	name := strings.TrimPrefix(path, publishPathPrefix)
+	for i := range s.WritableTables {
+		if s.WritableTables[i].Name == name {
+			return &s.WritableTables[i]
+		}
+	}
+	return nil
+}
+
+func (t writableTableModel) allows(user *backend.User) bool {
+	roles := t.Roles
+	if len(roles) == 0 {
+		roles = defaultWritableRoles
+	}
+	return hasRole(user, roles)
+}
+
+// hasRole 判断 Grafana 用户是否拥有 roles 中的某个角色
+func hasRole(user *backend.User, roles []string) bool {
+	if user == nil {
+		return false
+	}
+	for _, role := range roles {
+		if strings.EqualFold(role, user.Role) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePublishRows 解析写入的数据，可以是一个对象（一行）或者对象数组（多行）
+// 数字保留为 json.Number，按照目标列的类型再转换，避免大整数丢失精度
+func parsePublishRows(raw json.RawMessage) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var rows []map[string]interface{}
+		if err := decoder.Decode(&rows); err != nil {
+			return nil, fmt.Errorf("invalid rows: %w", err)
+		}
+		return rows, nil
+	}
+	var row map[string]interface{}
+	if err := decoder.Decode(&row); err != nil {
+		return nil, fmt.Errorf("invalid row: %w", err)
+	}
+	return []map[string]interface{}{row}, nil
+}
+
+// loadTableSchema 通过 schema().colDefs 获取目标表的列名和列类型
+func loadTableSchema(ref string, uid string, config db.DBConfig) ([]string, []db.ColumnType, error) {
+	df, err := db.RunSimpleScript(fmt.Sprintf("schema(%s).colDefs", ref), uid, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	tb, ok := df.(*model.Table)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected schema result %s", df.GetDataFormString())
+	}
+	names := tb.GetColumnByName("name")
+	typeStrings := tb.GetColumnByName("typeString")
+	typeInts := tb.GetColumnByName("typeInt")
+	if names == nil || typeStrings == nil || typeInts == nil {
+		return nil, nil, fmt.Errorf("unexpected schema columns %v", tb.GetColumnNames())
+	}
+
+	columns := make([]string, tb.Rows())
+	types := make([]db.ColumnType, tb.Rows())
+	for i := 0; i < tb.Rows(); i++ {
+		columns[i] = names.Get(i).String()
+		typeInt, ok := typeInts.Get(i).Value().(int32)
+		if !ok {
+			return nil, nil, fmt.Errorf("unexpected type of column %s", columns[i])
+		}
+		types[i] = db.ParseColumnType(typeInt, typeStrings.Get(i).String())
+	}
+	return columns, types, nil
+}
+
+// publishTask 生成把 rows 写入目标表的任务，数据作为 RunFunc 的参数传递，不拼接到脚本中
+func publishTask(target *writableTableModel, columns []string, types []db.ColumnType, rows []map[string]interface{}) (*api.Task, error) {
+	// 不在表结构中的列直接拒绝，避免拼错列名的数据被静默丢弃
+	known := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		known[c] = true
+	}
+	for i, row := range rows {
+		for name := range row {
+			if !known[name] {
+				return nil, fmt.Errorf("row %d: column %s does not exist in %s", i, name, target.Table)
+			}
+		}
+	}
+
+	tb, err := db.NewTableFromRows(columns, types, rows)
+	if err != nil {
+		return nil, fmt.Errorf("data does not match the schema of %s: %w", target.Table, err)
+	}
+	return &api.Task{
+		Script: fmt.Sprintf("tableInsert{%s}", tableRef(target.Database, target.Table)),
+		Args:   []model.DataForm{tb},
+	}, nil
+}
+
+// PublishStream 把面板通过 Grafana Live 发布的数据写入白名单中的流表或分布式表
+func (d *Datasource) PublishStream(_ context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	if req.PluginContext.DataSourceInstanceSettings == nil {
+		return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusNotFound}, nil
+	}
+	settings, err := parsePublishSettings(req.PluginContext.DataSourceInstanceSettings.JSONData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid writable tables setting: %w", err)
+	}
+	target := settings.find(req.Path)
+	if target == nil {
+		return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusNotFound}, nil
+	}
+	// 只读的数据源不能写入任何表，包括写入白名单中的表
+	readOnly, err := parseReadOnlySettings(req.PluginContext.DataSourceInstanceSettings.JSONData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid read-only setting: %w", err)
+	}
+	if readOnly.ReadOnly {
+		log.DefaultLogger.Warn("Publish denied on read-only datasource", "path", req.Path)
+		return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+	}
+	if !target.allows(req.PluginContext.User) {
+		log.DefaultLogger.Warn("Publish denied", "path", req.Path, "user", req.PluginContext.User)
+		return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+	}
+	if !isValidIdentifier(target.Table) {
+		return nil, fmt.Errorf("writable table %s is not a valid identifier", target.Table)
+	}
+
+	rows, err := parsePublishRows(req.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusOK}, nil
+	}
+
+	// 写入使用映射的 DolphinDB 用户，服务端的权限和审计日志可以区分写入的用户
+	uid, config, err := datasourceConfig(req.PluginContext)
+	if err != nil {
+		return nil, err
+	}
+	ref := tableRef(target.Database, target.Table)
+
+	columns, types, err := loadTableSchema(ref, uid, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load schema of %s: %w", target.Table, err)
+	}
+	task, err := publishTask(target, columns, types, rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.RunPoolTasks([]*api.Task{task}, uid, config); err != nil {
+		return nil, fmt.Errorf("unable to write to %s: %w", target.Table, err)
+	}
+	if !task.IsSuccess() {
+		return nil, fmt.Errorf("unable to write to %s: %s", target.Table, task.GetError())
+	}
+
+	log.DefaultLogger.Info("Published rows", "table", target.Table, "rows", len(rows), "user", req.PluginContext.User.Login)
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusOK}, nil
+}
@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const publishSettingsJSON = `{
+	"writableTables": [
+		{"name": "orders", "table": "orders"},
+		{"name": "quotes", "database": "dfs://StockDB", "table": "quotes", "roles": ["Editor", "Admin"]}
+	]
+}`
+
+func TestPublishSettingsFind(t *testing.T) {
+	settings, err := parsePublishSettings(json.RawMessage(publishSettingsJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := settings.find("publish/quotes"); target == nil || target.Database != "dfs://StockDB" {
+		t.Fatalf("unexpected target %v", target)
+	}
+	for _, path := range []string{"publish/trades", "quotes", "publish/", "publish/quotes/x", "query/orders"} {
+		if target := settings.find(path); target != nil {
+			t.Errorf("%s should not be writable, got %v", path, target)
+		}
+	}
+}
+
+func TestWritableTableAllows(t *testing.T) {
+	settings, err := parsePublishSettings(json.RawMessage(publishSettingsJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, quotes := settings.find("publish/orders"), settings.find("publish/quotes")
+	cases := []struct {
+		target *writableTableModel
+		user   *backend.User
+		allow  bool
+	}{
+		// 没有配置 roles 时只允许管理员
+		{orders, &backend.User{Login: "admin", Role: "Admin"}, true},
+		{orders, &backend.User{Login: "bob", Role: "Editor"}, false},
+		{quotes, &backend.User{Login: "bob", Role: "editor"}, true},
+		{quotes, &backend.User{Login: "eve", Role: "Viewer"}, false},
+		{quotes, &backend.User{Login: "anonymous"}, false},
+		{quotes, nil, false},
+	}
+	for _, c := range cases {
+		if got := c.target.allows(c.user); got != c.allow {
+			t.Errorf("%s %v: expected %v", c.target.Name, c.user, c.allow)
+		}
+	}
+}
+
+func TestPublishStreamRejected(t *testing.T) {
+	ds := &Datasource{}
+	pluginContext := func(role string) backend.PluginContext {
+		return backend.PluginContext{
+			User:                       &backend.User{Login: "u", Role: role},
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "ds", JSONData: json.RawMessage(publishSettingsJSON)},
+		}
+	}
+	cases := []struct {
+		path   string
+		role   string
+		status backend.PublishStreamStatus
+	}{
+		{"publish/trades", "Admin", backend.PublishStreamStatusNotFound},
+		{"publish/orders", "Editor", backend.PublishStreamStatusPermissionDenied},
+		{"publish/quotes", "Viewer", backend.PublishStreamStatusPermissionDenied},
+		// 通过检查、但没有数据时不会连接数据库
+		{"publish/quotes", "Editor", backend.PublishStreamStatusOK},
+	}
+	for _, c := range cases {
+		resp, err := ds.PublishStream(context.Background(), &backend.PublishStreamRequest{
+			PluginContext: pluginContext(c.role),
+			Path:          c.path,
+			Data:          json.RawMessage(`[]`),
+		})
+		if err != nil || resp.Status != c.status {
+			t.Errorf("%s as %s: expected %v, got %v %v", c.path, c.role, c.status, resp, err)
+		}
+	}
+
+	// 只读的数据源拒绝所有写入
+	readOnly := pluginContext("Admin")
+	readOnly.DataSourceInstanceSettings.JSONData = json.RawMessage(strings.Replace(publishSettingsJSON, "{", `{"readOnly": true,`, 1))
+	resp, err := ds.PublishStream(context.Background(), &backend.PublishStreamRequest{
+		PluginContext: readOnly,
+		Path:          "publish/quotes",
+		Data:          json.RawMessage(`{"sym": "A"}`),
+	})
+	if err != nil || resp.Status != backend.PublishStreamStatusPermissionDenied {
+		t.Fatalf("expected a read-only datasource to reject writes, got %v %v", resp, err)
+	}
+}
+
+func TestParsePublishRows(t *testing.T) {
+	rows, err := parsePublishRows(json.RawMessage(`{"sym": "A", "qty": 9007199254740993}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["qty"] != json.Number("9007199254740993") {
+		t.Fatalf("numbers must be kept as json.Number, got %v", rows)
+	}
+	rows, err = parsePublishRows(json.RawMessage(` [{"sym": "A"}, {"sym": "B"}]`))
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("unexpected rows %v %v", rows, err)
+	}
+	if _, err := parsePublishRows(json.RawMessage(`"A"`)); err == nil {
+		t.Fatal("expected an error for a non-object row")
+	}
+}
+
+func TestPublishTask(t *testing.T) {
+	target := &writableTableModel{Name: "quotes", Database: "dfs://StockDB", Table: "quotes"}
+	columns := []string{"ts", "sym", "qty", "price"}
+	types := []db.ColumnType{{Type: model.DtTimestamp}, {Type: model.DtSymbol}, {Type: model.DtLong}, {Type: model.DtDouble}}
+
+	rows, err := parsePublishRows(json.RawMessage(`[
+		{"ts": "2024-01-02T09:30:00.000Z", "sym": "A", "qty": 9007199254740993, "price": 10.5},
+		{"ts": "2024-01-02T09:30:01.000Z", "sym": "B"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := publishTask(target, columns, types, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Script != `tableInsert{loadTable("dfs://StockDB", "quotes")}` || len(task.Args) != 1 {
+		t.Fatalf("unexpected task %s %v", task.Script, task.Args)
+	}
+	tb, ok := task.Args[0].(*model.Table)
+	if !ok || tb.Rows() != 2 || strings.Join(tb.GetColumnNames(), ",") != "ts,sym,qty,price" {
+		t.Fatalf("unexpected argument %v", task.Args[0])
+	}
+	if qty := tb.GetColumnByName("qty").Get(0).Value(); qty != int64(9007199254740993) {
+		t.Fatalf("large integers must not lose precision, got %v", qty)
+	}
+	if !tb.GetColumnByName("price").Get(1).IsNull() {
+		t.Fatal("missing values must be null")
+	}
+
+	_, err = publishTask(target, columns, types, []map[string]interface{}{{"sym": "A"}, {"symbol": "B"}})
+	if err == nil || err.Error() != "row 1: column symbol does not exist in quotes" {
+		t.Fatalf("expected unknown columns to be rejected, got %v", err)
+	}
+	_, err = publishTask(target, columns, types, []map[string]interface{}{{"qty": "many"}})
+	if err == nil || !strings.Contains(err.Error(), "data does not match the schema of quotes") {
+		t.Fatalf("expected a type error, got %v", err)
+	}
+}
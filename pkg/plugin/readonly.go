@@ -0,0 +1,225 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 只读模式下默认禁止调用的函数，会修改数据库、表、会话或者服务器状态，
+// 以及可以间接执行任意脚本的函数
+var defaultDangerousFunctions = []string{
+	// 数据库和表
+	"dropDatabase", "dropTable", "dropPartition", "dropColumns!", "dropDistributedInMemoryTable",
+	"createPartitionedTable", "createTable", "createDimensionTable", "createDistributedInMemoryTable",
+	"addColumn", "addRangePartitions", "addValuePartitions", "rename!", "renameTable", "replaceColumn!",
+	"reorderColumns!", "setColumnComment", "truncate", "saveTable", "savePartition", "saveText", "loadTextEx",
+	"tableInsert", "tableUpsert", "append!", "upsert!", "update!", "erase!", "clear!",
+	// 备份、恢复和迁移
+	"backup", "backupDB", "backupTable", "restore", "restoreDB", "restoreTable", "migrate",
+	// 流数据和流计算引擎，create 开头的引擎函数由 dangerousFunctionPrefixes 拒绝
+	"share", "undef", "enableTableShareAndPersistence", "enableTablePersistence", "clearTablePersistence",
+	"dropStreamTable", "dropStreamEngine", "subscribeTable", "unsubscribeTable", "stopPublishTable",
+	"warmupStreamEngine", "registerSnapshotEngine", "unregisterSnapshotEngine", "appendForJoin",
+	// 函数视图
+	"addFunctionView", "dropFunctionView",
+	// 文件
+	"file", "writeLine", "writeLines", "writeBytes", "writeObject", "writeRecord", "saveAsNpy",
+	// 作业、会话和系统
+	"submitJob", "submitJobEx", "cancelJob", "cancelConsoleJob", "scheduleJob", "deleteScheduledJob",
+	"closeSessions", "shell", "rm", "rmdir", "mkdir", "saveModule", "setMaxMemSize", "loadPlugin",
+	// 用户和权限
+	"createUser", "deleteUser", "resetPwd", "changePwd", "grant", "deny", "revoke",
+	// 间接执行，包括生成 SQL 元代码再用 eval 执行
+	"runScript", "run", "parseExpr", "funcByName", "evalTimer", "eval", "sqlDelete", "sqlUpdate",
+	"remoteRun", "remoteRunWithCompression", "remoteRunCompatible",
+}
+
+// 只读模式下禁止调用的函数名前缀，例如 createReactiveStateEngine、dropCatalog
+// 前缀后面需要是大写字母，dropna 之类的普通函数不受影响
+var dangerousFunctionPrefixes = []string{"create", "drop"}
+
+// SQL 中修改数据的语句，值为关键字后面紧跟的关键字，例如 create table、drop database
+// 只有关键字本身时可能是列名或者变量名，例如 select create, update from t，不能拒绝
+var destructiveStatements = map[string][]string{
+	"delete": {"from"},
+	"insert": {"into"},
+	"create": {"table", "database", "catalog", "schema", "local"},
+	"drop":   {"table", "database", "catalog", "schema"},
+	"alter":  {"table"},
+}
+
+// readOnlySettingsModel 是数据源配置中的只读模式
+type readOnlySettingsModel struct {
+	ReadOnly bool `json:"readOnly"`
+	// 在默认列表之外额外禁止的函数
+	DangerousFunctions []string `json:"dangerousFunctions,omitempty"`
+}
+
+func parseReadOnlySettings(jsonData json.RawMessage) (readOnlySettingsModel, error) {
+	var settings readOnlySettingsModel
+	err := json.Unmarshal(jsonData, &settings)
+	return settings, err
+}
+
+func (s readOnlySettingsModel) isDangerous(name string) bool {
+	if containsString(defaultDangerousFunctions, name) || containsString(s.DangerousFunctions, name) {
+		return true
+	}
+	for _, prefix := range dangerousFunctionPrefixes {
+		if rest, ok := strings.CutPrefix(name, prefix); ok && rest != "" && rest[0] >= 'A' && rest[0] <= 'Z' {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFunction 检查函数调用查询的函数名
+func (s readOnlySettingsModel) checkFunction(name string) error {
+	if s.ReadOnly && s.isDangerous(name) {
+		return fmt.Errorf("read-only datasource rejects function %s", name)
+	}
+	return nil
+}
+
+// checkScript 在只读模式下拒绝修改数据的 SQL 语句和危险的函数，错误中包含出错的语句所在的行
+func (s readOnlySettingsModel) checkScript(script string) error {
+	if !s.ReadOnly {
+		return nil
+	}
+	tokens := tokenizeScript(script)
+	for i, tok := range tokens {
+		if tok.kind != tokenIdentifier {
+			continue
+		}
+		var reason string
+		if s.isDangerous(tok.text) {
+			reason = fmt.Sprintf("function %s", tok.text)
+		} else if keyword := strings.ToLower(tok.text); keyword == "update" && isUpdateStatement(tokens[i+1:]) {
+			reason = "update statement"
+		} else if follows, ok := destructiveStatements[keyword]; ok && i+1 < len(tokens) {
+			next := tokens[i+1]
+			if next.kind == tokenIdentifier && containsString(follows, strings.ToLower(next.text)) {
+				reason = fmt.Sprintf("%s statement", keyword)
+			}
+		}
+		if reason != "" {
+			return fmt.Errorf("read-only datasource rejects %s at line %d: %s", reason, tok.line, statementAt(script, tok.line))
+		}
+	}
+	return nil
+}
+
+// isUpdateStatement 判断 update 后面是否为表名（可以是 catalog.schema.table）和 set
+func isUpdateStatement(tokens []scriptToken) bool {
+	i := 0
+	for {
+		if i >= len(tokens) || tokens[i].kind != tokenIdentifier {
+			return false
+		}
+		i++
+		if i < len(tokens) && tokens[i].text == "." {
+			i++
+			continue
+		}
+		return i < len(tokens) && tokens[i].kind == tokenIdentifier && strings.EqualFold(tokens[i].text, "set")
+	}
+}
+
+// statementAt 返回脚本中第 line 行的内容
+func statementAt(script string, line int) string {
+	lines := strings.Split(script, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line-1])
+}
+
+type tokenKind int
+
+const (
+	tokenIdentifier tokenKind = iota
+	tokenOther
+)
+
+type scriptToken struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+// tokenizeScript 把 DolphinDB 脚本切分为标识符和其他符号，跳过注释、字符串和 symbol 字面量
+// 以 ! 结尾的函数名（例如 append!）作为一个标识符
+func tokenizeScript(script string) []scriptToken {
+	var tokens []scriptToken
+	line := 1
+	n := len(script)
+	for i := 0; i < n; {
+		c := script[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '/' && i+1 < n && script[i+1] == '/':
+			for i < n && script[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < n && script[i+1] == '*':
+			i += 2
+			for i < n && !(script[i] == '*' && i+1 < n && script[i+1] == '/') {
+				if script[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			i += 2
+		case c == '"' || c == '\'':
+			i++
+			for i < n && script[i] != c {
+				if script[i] == '\\' {
+					i++
+				} else if script[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			i++
+			tokens = append(tokens, scriptToken{kind: tokenOther, text: "string", line: line})
+		case c == '`':
+			i++
+			for i < n && isIdentifierChar(script[i]) {
+				i++
+			}
+			tokens = append(tokens, scriptToken{kind: tokenOther, text: "symbol", line: line})
+		case isIdentifierStart(c):
+			start := i
+			for i < n && isIdentifierChar(script[i]) {
+				i++
+			}
+			if i < n && script[i] == '!' && !(i+1 < n && script[i+1] == '=') {
+				i++
+			}
+			tokens = append(tokens, scriptToken{kind: tokenIdentifier, text: script[start:i], line: line})
+		case c >= '0' && c <= '9':
+			// 数字和时间字面量，例如 2024.01.02T09:30:00.000、1m
+			for i < n && (isIdentifierChar(script[i]) || script[i] == '.' || script[i] == ':') {
+				i++
+			}
+			tokens = append(tokens, scriptToken{kind: tokenOther, text: "number", line: line})
+		default:
+			tokens = append(tokens, scriptToken{kind: tokenOther, text: string(c), line: line})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyCheckScript(t *testing.T) {
+	s := readOnlySettingsModel{ReadOnly: true, DangerousFunctions: []string{"myCleanup"}}
+	allowed := []string{
+		"select * from t where sym = \"delete from t\" // dropDatabase(\"dfs://x\")",
+		"select avg(price) from loadTable(\"dfs://db\", `trades) group by sym",
+		"/* update t set x = 1 */ t = select * from pt where date = 2024.01.02\nselect count(*) from t",
+		"select * from t where a != b",
+		"select dropna(price) as p from t",
+		"select created from t",
+		// 和语句关键字同名的列和变量
+		"select create, update from t",
+		"select update from t where drop > 0",
+		"update = 1\ncreate = update + 1",
+		"select alter, insert from t order by update",
+	}
+	for _, script := range allowed {
+		if err := s.checkScript(script); err != nil {
+			t.Fatalf("unexpected error for %q: %v", script, err)
+		}
+	}
+
+	rejected := map[string]string{
+		"t = select * from pt\nDELETE FROM pt where x > 1": "line 2: DELETE FROM pt where x > 1",
+		"dropDatabase(\"dfs://db\")":                       "function dropDatabase",
+		"pt.append!(t)":                                    "function append!",
+		"update pt set x = 1":                              "update statement",
+		"insert into pt values(1, 2)":                      "insert statement",
+		"myCleanup()":                                      "function myCleanup",
+		"each(dropTable{db}, `a`b)":                        "function dropTable",
+		// 生成 SQL 元代码再执行
+		"eval(sqlDelete(table=pt))":               "function eval",
+		"sqlUpdate(table=pt, updates=<1 as x>)":   "function sqlUpdate",
+		"remoteRun(conn, \"dropTable(db, `pt)\")": "function remoteRun",
+		// 流计算引擎、函数视图、发布、备份和文件
+		"createReactiveStateEngine(name=`e, metrics=<cumsum(x)>, dummyTable=t, outputTable=o, keyColumn=`sym)": "function createReactiveStateEngine",
+		"createTimeSeriesEngine(`e, 60000, 60000, <sum(x)>, t, o)":                                             "function createTimeSeriesEngine",
+		"dropFunctionView(`f)":                                     "function dropFunctionView",
+		"addFunctionView(f)":                                       "function addFunctionView",
+		"stopPublishTable(\"localhost\", 8848, `st, `a)":           "function stopPublishTable",
+		"restore(\"/backup\", \"dfs://db\", `pt, \"%\", true)":     "function restore",
+		"migrate(\"/backup\")":                                     "function migrate",
+		"f = file(\"/tmp/x\", \"w\")\nf.writeLine(\"x\")":          "function file",
+		"h.writeLine(\"x\")":                                       "function writeLine",
+		"create database \"dfs://db\" partitioned by VALUE(1..10)": "create statement",
+		"CREATE TABLE \"dfs://db\".\"pt\"(x INT)":                  "create statement",
+		"drop table if exists pt":                                  "drop statement",
+		"alter table pt add y INT":                                 "alter statement",
+		"UPDATE pt SET x = 1 where y > 0":                          "update statement",
+		"update trading.stock.pt set x = 1":                        "update statement",
+	}
+	for script, want := range rejected {
+		err := s.checkScript(script)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q for %q, got %v", want, script, err)
+		}
+	}
+
+	if err := (readOnlySettingsModel{}).checkScript("dropDatabase(\"dfs://db\")"); err != nil {
+		t.Fatalf("read-only mode is disabled, got %v", err)
+	}
+	if err := s.checkFunction("dropTable"); err == nil {
+		t.Fatal("expected function call to be rejected")
+	}
+}
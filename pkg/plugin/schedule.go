@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// 告警查询由 Grafana 在请求头中标记
+const fromAlertHeader = "FromAlert"
+
+// 前端填写的发起查询的应用，和 @grafana/data 的 CoreApp 一致
+const appExplore = "explore"
+
+// schedulerSettingsModel 是数据源配置中的查询调度，maxConcurrentQueries 为 0 时不限制
+type schedulerSettingsModel struct {
+	MaxConcurrentQueries int `json:"maxConcurrentQueries,omitempty"`
+	QueueTimeoutSeconds  int `json:"queueTimeoutSeconds,omitempty"`
+}
+
+func parseSchedulerSettings(jsonData json.RawMessage) (schedulerSettingsModel, error) {
+	var settings schedulerSettingsModel
+	err := json.Unmarshal(jsonData, &settings)
+	return settings, err
+}
+
+func (s schedulerSettingsModel) config() db.SchedulerConfig {
+	return db.SchedulerConfig{
+		MaxConcurrent: s.MaxConcurrentQueries,
+		QueueTimeout:  time.Duration(s.QueueTimeoutSeconds) * time.Second,
+	}
+}
+
+// queryPriority 告警查询优先于仪表盘，仪表盘优先于 Explore
+func queryPriority(headers map[string]string, qm queryModel) db.Priority {
+	if headers[fromAlertHeader] == "true" {
+		return db.PriorityAlerting
+	}
+	if qm.App == appExplore {
+		return db.PriorityExplore
+	}
+	return db.PriorityDashboard
+}
+
+// queryUser 返回用来在用户之间轮流调度的用户名
+func queryUser(user *backend.User) string {
+	if user == nil {
+		return ""
+	}
+	return user.Login
+}
@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// 插件创建的订阅 action 都以这个前缀开头，方便在发布端识别
+const streamActionPrefix = "grafana"
+
+// 当前进程中正在使用的订阅 action，清理时不能取消这些订阅
+var activeStreamActions sync.Map
+
+// pluginInstanceID 标识当前 Grafana 实例，多个 Grafana 共用一个 DolphinDB 时不会清理到别人的订阅
+// 使用主机名而不是随机数，这样重启后依然能认出自己之前创建的订阅
+var pluginInstanceID = func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return shortHash(hostname)
+}()
+
+func shortHash(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// streamActionPrefixFor 返回某个数据源创建的所有订阅 action 共同的前缀
+func streamActionPrefixFor(uid string) string {
+	return fmt.Sprintf("%s_%s_%s_", streamActionPrefix, pluginInstanceID, shortHash(uid))
+}
+
+// streamActionName 根据数据源 UID 和 Grafana Live 的 channel 路径生成确定的 action 名称
+// 同一个 channel 同一时间只会有一个 RunStream，所以名称不会冲突
+func streamActionName(uid string, channelPath string) string {
+	return streamActionPrefixFor(uid) + shortHash(channelPath)
+}
+
+type publishedAction struct {
+	table      string
+	subscriber string
+	action     string
+}
+
+// listPublishedActions 通过 getStreamingStat 列出发布端上所有的订阅
+func listPublishedActions(uid string, config db.DBConfig) ([]publishedAction, error) {
+	df, err := db.RunSimpleScript("getStreamingStat().pubTables", uid, config)
+	if err != nil {
+		return nil, err
+	}
+	tb, ok := df.(*model.Table)
+	if !ok {
+		return nil, fmt.Errorf("unexpected getStreamingStat result %s", df.GetDataFormString())
+	}
+	return parsePublishedActions(tb)
+}
+
+// parsePublishedActions 解析 getStreamingStat().pubTables，每个订阅端的每个 action 一行
+func parsePublishedActions(tb *model.Table) ([]publishedAction, error) {
+	tables := tb.GetColumnByName("tableName")
+	subscribers := tb.GetColumnByName("subscriber")
+	actionsCol := tb.GetColumnByName("actions")
+	if tables == nil || subscribers == nil || actionsCol == nil {
+		return nil, fmt.Errorf("unexpected getStreamingStat columns %v", tb.GetColumnNames())
+	}
+
+	var result []publishedAction
+	for i := 0; i < tb.Rows(); i++ {
+		// 一个订阅端可能有多个 action，格式为 action1 或者 [action1,action2]
+		actions := strings.Trim(actionsCol.Get(i).String(), "[]")
+		for _, action := range strings.Split(actions, ",") {
+			action = strings.TrimSpace(action)
+			if action == "" {
+				continue
+			}
+			result = append(result, publishedAction{
+				table:      tables.Get(i).String(),
+				subscriber: subscribers.Get(i).String(),
+				action:     action,
+			})
+		}
+	}
+	return result, nil
+}
+
+// cleanupStreamActions 取消这个数据源之前创建、但当前进程已经不再使用的订阅
+// only 不为空时只清理这个 action
+func cleanupStreamActions(uid string, config db.DBConfig, only string) {
+	published, err := listPublishedActions(uid, config)
+	if err != nil {
+		log.DefaultLogger.Warn("Unable to list streaming subscriptions", "error", err)
+		return
+	}
+
+	prefix := streamActionPrefixFor(uid)
+	for _, p := range published {
+		if !strings.HasPrefix(p.action, prefix) || (only != "" && p.action != only) {
+			continue
+		}
+		if _, active := activeStreamActions.Load(p.action); active {
+			continue
+		}
+		script, err := stopPublishScript(p)
+		if err != nil {
+			log.DefaultLogger.Warn("Unable to parse subscriber", "subscriber", p.subscriber, "error", err)
+			continue
+		}
+		if _, err := db.RunSimpleScript(script, uid, config); err != nil {
+			log.DefaultLogger.Warn("Unable to remove stale subscription", "table", p.table, "action", p.action, "error", err)
+			continue
+		}
+		log.DefaultLogger.Info("Removed stale subscription", "table", p.table, "action", p.action)
+	}
+}
+
+// stopPublishScript 生成在发布端取消这个订阅的脚本
+func stopPublishScript(p publishedAction) (string, error) {
+	host, port, err := splitSubscriber(p.subscriber)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("stopPublishTable(%q, %d, %q, %q)", host, port, p.table, p.action), nil
+}
+
+func splitSubscriber(subscriber string) (string, int, error) {
+	idx := strings.LastIndex(subscriber, ":")
+	if idx < 0 {
+		return "", 0, fmt.Errorf("invalid subscriber %s", subscriber)
+	}
+	port, err := strconv.Atoi(subscriber[idx+1:])
+	if err != nil {
+		return "", 0, err
+	}
+	return subscriber[:idx], port, nil
+}
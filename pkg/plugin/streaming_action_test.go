@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/dolphindb/api-go/v3/model"
+)
+
+func TestStreamActionName(t *testing.T) {
+	name := streamActionName("ds1", "ds/1/trades")
+	if name != streamActionName("ds1", "ds/1/trades") {
+		t.Fatal("action name must be stable for the same channel")
+	}
+	if !strings.HasPrefix(name, streamActionPrefixFor("ds1")) || !strings.HasPrefix(name, streamActionPrefix+"_") {
+		t.Fatalf("unexpected prefix %s", name)
+	}
+	if !isValidIdentifier(name) {
+		t.Fatalf("action name %s is not a valid identifier", name)
+	}
+
+	seen := map[string]string{}
+	for _, c := range []struct{ uid, path string }{
+		{"ds1", "ds/1/trades"},
+		{"ds1", "ds/1/quotes"},
+		{"ds2", "ds/1/trades"},
+		{"ds1", "ds/1/trades/latest"},
+	} {
+		name := streamActionName(c.uid, c.path)
+		if other, ok := seen[name]; ok {
+			t.Fatalf("%s/%s and %s share action name %s", c.uid, c.path, other, name)
+		}
+		seen[name] = c.uid + "/" + c.path
+	}
+}
+
+func stringVector(t *testing.T, values ...interface{}) *model.Vector {
+	t.Helper()
+	vec, err := db.NewVectorFromValues(db.ColumnType{Type: model.DtString}, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vec
+}
+
+func TestParsePublishedActions(t *testing.T) {
+	tb := model.NewTable([]string{"tableName", "subscriber", "msgOffset", "actions"}, []*model.Vector{
+		stringVector(t, "trades", "quotes", "orders"),
+		stringVector(t, "10.0.0.1:8101", "10.0.0.1:8101", "10.0.0.2:8101"),
+		stringVector(t, "0", "0", "0"),
+		stringVector(t, "grafana_a", "[grafana_a, grafana_b]", ""),
+	})
+	got, err := parsePublishedActions(tb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []publishedAction{
+		{table: "trades", subscriber: "10.0.0.1:8101", action: "grafana_a"},
+		{table: "quotes", subscriber: "10.0.0.1:8101", action: "grafana_a"},
+		{table: "quotes", subscriber: "10.0.0.1:8101", action: "grafana_b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected actions %v", got)
+	}
+
+	_, err = parsePublishedActions(model.NewTable([]string{"tableName"}, []*model.Vector{stringVector(t, "trades")}))
+	if err == nil {
+		t.Fatal("expected an error when columns are missing")
+	}
+}
+
+func TestStopPublishScript(t *testing.T) {
+	cases := []struct {
+		subscriber string
+		script     string
+		err        bool
+	}{
+		{"10.0.0.1:8101", `stopPublishTable("10.0.0.1", 8101, "trades", "grafana_a")`, false},
+		{"ddb-node:8848", `stopPublishTable("ddb-node", 8848, "trades", "grafana_a")`, false},
+		{"10.0.0.1", "", true},
+		{"10.0.0.1:port", "", true},
+	}
+	for _, c := range cases {
+		script, err := stopPublishScript(publishedAction{table: "trades", subscriber: c.subscriber, action: "grafana_a"})
+		if (err != nil) != c.err || script != c.script {
+			t.Errorf("%s: got %q, %v", c.subscriber, script, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// latestValueStore 按键列保存每个键最新的一行流数据，用于 latest 模式
+// 行的顺序按键第一次出现的顺序，这样表格面板里的行不会来回跳
+type latestValueStore struct {
+	keyColumns []string
+	keys       []string
+	rows       map[string][]*data.Field
+	// 自上次快照以来是否有更新，没有更新就不用重复推送
+	changed bool
+}
+
+func newLatestValueStore(keyColumns []string) *latestValueStore {
+	return &latestValueStore{
+		keyColumns: keyColumns,
+		rows:       make(map[string][]*data.Field),
+	}
+}
+
+// Update 用一行流数据（每个 field 只有一个值）覆盖对应键的旧值
+func (s *latestValueStore) Update(row []*data.Field) {
+	key := s.rowKey(row)
+	if _, exists := s.rows[key]; !exists {
+		s.keys = append(s.keys, key)
+	}
+	s.rows[key] = row
+	s.changed = true
+}
+
+// Snapshot 把所有键的最新行拼成一个完整的 frame
+func (s *latestValueStore) Snapshot(framename string) *data.Frame {
+	frame := data.NewFrame(framename)
+	s.changed = false
+	if len(s.keys) == 0 {
+		return frame
+	}
+
+	// 以第一行的列作为快照的列
+	first := s.rows[s.keys[0]]
+	for i, f := range first {
+		field := data.NewFieldFromFieldType(f.Type(), len(s.keys))
+		field.Name = f.Name
+		for r, key := range s.keys {
+			row := s.rows[key]
+			// 列结构对不上的行，这一列就留空
+			if i >= len(row) || row[i].Name != f.Name || row[i].Type() != f.Type() || row[i].Len() == 0 {
+				continue
+			}
+			field.Set(r, row[i].At(0))
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+
+	return frame
+}
+
+func (s *latestValueStore) rowKey(row []*data.Field) string {
+	parts := make([]string, len(s.keyColumns))
+	for i, name := range s.keyColumns {
+		parts[i] = "null"
+		for _, f := range row {
+			if f.Name == name && f.Len() > 0 {
+				parts[i] = formatFieldValue(f.At(0))
+				break
+			}
+		}
+	}
+	return strings.Join(parts, "\x1f")
+}
+
+// formatFieldValue 把 field 中的值（通常是指针）转换成字符串
+func formatFieldValue(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || (rv.Kind() == reflect.Pointer && rv.IsNil()) {
+		return "null"
+	}
+	return fmt.Sprintf("%v", reflect.Indirect(rv).Interface())
+}
@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func latestRow(sym string, price float64) []*data.Field {
+	return []*data.Field{
+		data.NewField("sym", nil, []*string{&sym}),
+		data.NewField("price", nil, []*float64{&price}),
+	}
+}
+
+func TestLatestValueStore(t *testing.T) {
+	store := newLatestValueStore([]string{"sym"})
+	store.Update(latestRow("A", 1))
+	store.Update(latestRow("B", 2))
+	store.Update(latestRow("A", 3))
+
+	frame := store.Snapshot("snapshot")
+	if store.changed {
+		t.Fatal("snapshot must reset the changed flag")
+	}
+	rows, err := frame.RowLen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Fatalf("expected 2 rows, got %d", rows)
+	}
+	if sym := *frame.Fields[0].At(0).(*string); sym != "A" {
+		t.Fatalf("expected first key A, got %s", sym)
+	}
+	if price := *frame.Fields[1].At(0).(*float64); price != 3 {
+		t.Fatalf("expected latest price 3, got %v", price)
+	}
+}
@@ -0,0 +1,266 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// 主表的行最多等待其他表这么久，超时后用已经收到的数据合并
+	defaultMergeWait = time.Second
+	// 检查等待中的行是否可以合并的间隔
+	mergeTick = 100 * time.Millisecond
+	// 其他表最多缓存的行数，主表长时间没有数据时丢弃最早的行
+	maxAsofBufferRows = 10000
+)
+
+// streamingMergeModel 描述多张流数据表按时间列做 as-of 合并的方式
+// 第一张表为主表，主表的每一行和其他表中时间不晚于它的最近一行合并
+type streamingMergeModel struct {
+	TimeColumn string `json:"timeColumn"`
+	// 其他表的行和主表的行之间允许的最大时间差，0 表示不限制
+	ToleranceMs int64 `json:"toleranceMs,omitempty"`
+	// 主表的行等待其他表数据的最长时间，单位毫秒
+	MaxWaitMs int64 `json:"maxWaitMs,omitempty"`
+}
+
+// streamMessage 是订阅回调发给 RunStream 的一条消息，table 为消息所属的流数据表
+type streamMessage struct {
+	table  string
+	fields []*data.Field
+}
+
+type asofRow struct {
+	t      time.Time
+	fields []*data.Field
+}
+
+type pendingRow struct {
+	asofRow
+	arrival time.Time
+}
+
+// asofMerger 在插件内把多张流数据表按时间对齐合并为一个 frame
+type asofMerger struct {
+	model     streamingMergeModel
+	tolerance time.Duration
+	wait      time.Duration
+	primary   string
+	// 其他表按订阅顺序排列，和输出列的顺序一致
+	secondary []string
+	// 每张表的列，用来在没有匹配行时补空值
+	templates map[string][]*data.Field
+	pending   []pendingRow
+	// 其他表按时间排序的最近的行，以及收到的最大时间
+	buffers map[string][]asofRow
+	maxTime map[string]time.Time
+}
+
+// newAsofMerger 创建合并器，templates 为每张表的列，tables 中第一张表为主表
+func newAsofMerger(model streamingMergeModel, tables []string, templates map[string][]*data.Field) (*asofMerger, error) {
+	if len(tables) < 2 {
+		return nil, errors.New("merging requires at least two streaming tables")
+	}
+	if model.TimeColumn == "" {
+		return nil, errors.New("merging requires a time column")
+	}
+	for _, table := range tables {
+		if !containsField(templates[table], model.TimeColumn) {
+			return nil, fmt.Errorf("time column %s does not exist in streaming table %s", model.TimeColumn, table)
+		}
+	}
+	wait := time.Duration(model.MaxWaitMs) * time.Millisecond
+	if wait <= 0 {
+		wait = defaultMergeWait
+	}
+	return &asofMerger{
+		model:     model,
+		tolerance: time.Duration(model.ToleranceMs) * time.Millisecond,
+		wait:      wait,
+		primary:   tables[0],
+		secondary: tables[1:],
+		templates: templates,
+		buffers:   make(map[string][]asofRow),
+		maxTime:   make(map[string]time.Time),
+	}, nil
+}
+
+func containsField(fields []*data.Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Add 收到一条消息，没有时间的消息无法对齐，直接丢弃
+func (m *asofMerger) Add(msg streamMessage, arrival time.Time) bool {
+	idx := m.timeIndex(msg.fields)
+	if idx < 0 {
+		return false
+	}
+	t, ok := fieldTime(msg.fields[idx])
+	if !ok {
+		return false
+	}
+	row := asofRow{t: t, fields: msg.fields}
+	if msg.table == m.primary {
+		m.pending = append(m.pending, pendingRow{asofRow: row, arrival: arrival})
+		return true
+	}
+	// 按时间插入，乱序到达的行也能正确匹配，按顺序到达时直接追加在末尾
+	rows := m.buffers[msg.table]
+	pos := sort.Search(len(rows), func(i int) bool { return rows[i].t.After(t) })
+	rows = append(rows, asofRow{})
+	copy(rows[pos+1:], rows[pos:])
+	rows[pos] = row
+	m.buffers[msg.table] = rows
+	if t.After(m.maxTime[msg.table]) {
+		m.maxTime[msg.table] = t
+	}
+
+	// Flush 只在主表有数据时清理缓存，主表没有数据时在这里清理，避免缓存无限增长
+	// 有容差时，早于 maxTime - tolerance 的行只能匹配更早的主表行，只需要保留等待中的主表行可能匹配的行
+	if m.tolerance > 0 {
+		bound := m.maxTime[msg.table].Add(-m.tolerance)
+		if len(m.pending) > 0 && m.pending[0].t.Before(bound) {
+			bound = m.pending[0].t
+		}
+		m.pruneTable(msg.table, bound)
+	}
+	if rows := m.buffers[msg.table]; len(rows) > maxAsofBufferRows {
+		m.buffers[msg.table] = rows[len(rows)-maxAsofBufferRows:]
+	}
+	return true
+}
+
+func (m *asofMerger) timeIndex(fields []*data.Field) int {
+	for i, f := range fields {
+		if f.Name == m.model.TimeColumn {
+			return i
+		}
+	}
+	return -1
+}
+
+// Flush 合并所有可以合并的主表行，其他表的数据都已经追上，或者等待超时
+// 没有可以输出的行时返回 nil
+func (m *asofMerger) Flush(now time.Time, framename string) *data.Frame {
+	var ready []pendingRow
+	for len(m.pending) > 0 {
+		row := m.pending[0]
+		if !m.caughtUp(row.t) && now.Sub(row.arrival) < m.wait {
+			break
+		}
+		ready = append(ready, row)
+		m.pending = m.pending[1:]
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+
+	frame := m.newFrame(framename)
+	for _, row := range ready {
+		values := append([]*data.Field{}, row.fields...)
+		for _, table := range m.secondary {
+			match := m.match(table, row.t)
+			values = append(values, m.columns(table, match)...)
+		}
+		appendMergedRow(frame, values)
+	}
+	m.prune(ready[len(ready)-1].t)
+	return frame
+}
+
+// caughtUp 判断其他表是否都已经收到时间不早于 t 的数据
+func (m *asofMerger) caughtUp(t time.Time) bool {
+	for _, table := range m.secondary {
+		if m.maxTime[table].Before(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// match 找到 table 中时间不晚于 t 的最近一行，超出容差时没有匹配
+func (m *asofMerger) match(table string, t time.Time) []*data.Field {
+	rows := m.buffers[table]
+	idx := sort.Search(len(rows), func(i int) bool { return rows[i].t.After(t) }) - 1
+	if idx < 0 {
+		return nil
+	}
+	if m.tolerance > 0 && t.Sub(rows[idx].t) > m.tolerance {
+		return nil
+	}
+	return rows[idx].fields
+}
+
+// prune 删除以后不会再被匹配的行，只保留时间不晚于 t 的最后一行
+func (m *asofMerger) prune(t time.Time) {
+	for table := range m.buffers {
+		m.pruneTable(table, t)
+	}
+}
+
+// pruneTable 只移动切片的开始，不复制剩下的行，前面的行在下次 append 重新分配时释放
+func (m *asofMerger) pruneTable(table string, t time.Time) {
+	rows := m.buffers[table]
+	idx := sort.Search(len(rows), func(i int) bool { return rows[i].t.After(t) }) - 1
+	if idx > 0 {
+		m.buffers[table] = rows[idx:]
+	}
+}
+
+// columns 返回 table 的一行，列名加上表名前缀，没有匹配时为空值
+func (m *asofMerger) columns(table string, row []*data.Field) []*data.Field {
+	template := m.templates[table]
+	fields := make([]*data.Field, len(template))
+	for i, tf := range template {
+		fields[i] = data.NewFieldFromFieldType(tf.Type(), 1)
+		fields[i].Name = mergedColumnName(table, tf.Name)
+		for _, f := range row {
+			if f.Name == tf.Name && f.Type() == tf.Type() && f.Len() > 0 {
+				fields[i].Set(0, f.At(0))
+			}
+		}
+	}
+	return fields
+}
+
+func mergedColumnName(table string, column string) string {
+	return table + "." + column
+}
+
+func (m *asofMerger) newFrame(framename string) *data.Frame {
+	frame := data.NewFrame(framename)
+	for _, tf := range m.templates[m.primary] {
+		frame.Fields = append(frame.Fields, data.NewFieldFromFieldType(tf.Type(), 0))
+		frame.Fields[len(frame.Fields)-1].Name = tf.Name
+	}
+	for _, table := range m.secondary {
+		for _, tf := range m.templates[table] {
+			field := data.NewFieldFromFieldType(tf.Type(), 0)
+			field.Name = mergedColumnName(table, tf.Name)
+			frame.Fields = append(frame.Fields, field)
+		}
+	}
+	return frame
+}
+
+// appendMergedRow 按列名把一行写入 frame，类型不一致或者缺失的列为空值
+func appendMergedRow(frame *data.Frame, values []*data.Field) {
+	for _, field := range frame.Fields {
+		field.Extend(1)
+		for _, v := range values {
+			if v.Name == field.Name && v.Type() == field.Type() && v.Len() > 0 {
+				field.Set(field.Len()-1, v.At(0))
+				break
+			}
+		}
+	}
+}
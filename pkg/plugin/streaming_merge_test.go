@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func mergeRow(ts time.Time, name string, value float64) []*data.Field {
+	return []*data.Field{
+		data.NewField("ts", nil, []*time.Time{&ts}),
+		data.NewField(name, nil, []*float64{&value}),
+	}
+}
+
+func TestAsofMerger(t *testing.T) {
+	templates := map[string][]*data.Field{
+		"trades": mergeRow(time.Time{}, "price", 0),
+		"quotes": mergeRow(time.Time{}, "bid", 0),
+	}
+	merger, err := newAsofMerger(streamingMergeModel{TimeColumn: "ts", ToleranceMs: 500}, []string{"trades", "quotes"}, templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	merger.Add(streamMessage{table: "quotes", fields: mergeRow(base, "bid", 9)}, base)
+	merger.Add(streamMessage{table: "trades", fields: mergeRow(base.Add(200*time.Millisecond), "price", 10)}, base)
+	if frame := merger.Flush(base, "m"); frame != nil {
+		t.Fatal("trade must wait until quotes catch up")
+	}
+
+	merger.Add(streamMessage{table: "quotes", fields: mergeRow(base.Add(300*time.Millisecond), "bid", 11)}, base)
+	frame := merger.Flush(base, "m")
+	if frame == nil || frame.Rows() != 1 {
+		t.Fatalf("expected one merged row, got %v", frame)
+	}
+	bid, _ := frame.FieldByName("quotes.bid")
+	if v := bid.At(0).(*float64); v == nil || *v != 9 {
+		t.Fatalf("expected the quote at or before the trade, got %v", v)
+	}
+
+	// 超出容差的行不匹配，等待超时后依然输出
+	merger.Add(streamMessage{table: "trades", fields: mergeRow(base.Add(2*time.Second), "price", 12)}, base)
+	frame = merger.Flush(base.Add(defaultMergeWait), "m")
+	if frame == nil || frame.Rows() != 1 {
+		t.Fatalf("expected the trade to be emitted after waiting, got %v", frame)
+	}
+	bid, _ = frame.FieldByName("quotes.bid")
+	if v := bid.At(0).(*float64); v != nil {
+		t.Fatalf("quotes outside the tolerance must not match, got %v", *v)
+	}
+}
+
+func TestAsofMergerQuietPrimary(t *testing.T) {
+	templates := map[string][]*data.Field{
+		"trades": mergeRow(time.Time{}, "price", 0),
+		"quotes": mergeRow(time.Time{}, "bid", 0),
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 有容差时只保留最近的行
+	merger, err := newAsofMerger(streamingMergeModel{TimeColumn: "ts", ToleranceMs: 1000}, []string{"trades", "quotes"}, templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10000; i++ {
+		merger.Add(streamMessage{table: "quotes", fields: mergeRow(base.Add(time.Duration(i)*time.Millisecond), "bid", float64(i))}, base)
+	}
+	if n := len(merger.buffers["quotes"]); n > 1002 {
+		t.Fatalf("quotes must be pruned while trades are quiet, got %d rows", n)
+	}
+	merger.Add(streamMessage{table: "trades", fields: mergeRow(base.Add(9999*time.Millisecond), "price", 1)}, base)
+	frame := merger.Flush(base, "m")
+	bid, _ := frame.FieldByName("quotes.bid")
+	if v := bid.At(0).(*float64); v == nil || *v != 9999 {
+		t.Fatalf("expected the latest quote, got %v", v)
+	}
+
+	// 等待中的主表行可能匹配的行不能被清理
+	merger.Add(streamMessage{table: "trades", fields: mergeRow(base.Add(10500*time.Millisecond), "price", 2)}, base)
+	for i := 0; i < 3000; i++ {
+		merger.Add(streamMessage{table: "quotes", fields: mergeRow(base.Add(10400*time.Millisecond+time.Duration(i)*time.Millisecond), "bid", float64(i))}, base)
+	}
+	frame = merger.Flush(base, "m")
+	bid, _ = frame.FieldByName("quotes.bid")
+	if v := bid.At(0).(*float64); v == nil || *v != 100 {
+		t.Fatalf("expected the quote at or before the pending trade, got %v", v)
+	}
+
+	// 没有容差时按行数限制
+	merger, err = newAsofMerger(streamingMergeModel{TimeColumn: "ts"}, []string{"trades", "quotes"}, templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxAsofBufferRows+100; i++ {
+		merger.Add(streamMessage{table: "quotes", fields: mergeRow(base.Add(time.Duration(i)*time.Millisecond), "bid", float64(i))}, base)
+	}
+	if n := len(merger.buffers["quotes"]); n != maxAsofBufferRows {
+		t.Fatalf("expected the buffer to be capped at %d rows, got %d", maxAsofBufferRows, n)
+	}
+}
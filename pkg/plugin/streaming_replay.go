@@ -0,0 +1,235 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// 每批从数据库读取的默认数据时间跨度
+	defaultReplayBatch = time.Minute
+	// 回放时把目标时间相近的行合并成一个 frame 推送，减少推送次数
+	replayTick = 50 * time.Millisecond
+	// DolphinDB 的 timestamp 字面量格式
+	ddbTimestampLayout = "2006.01.02T15:04:05.000"
+	// DolphinDB 的 nanotimestamp 字面量格式
+	ddbNanotimestampLayout = "2006.01.02T15:04:05.000000000"
+)
+
+// 前端 $__timeFilter 等使用的时间格式，回放的起止时间也使用这些格式
+var ddbTimeLayouts = []string{
+	"2006.01.02 15:04:05.000",
+	"2006.01.02T15:04:05.000",
+	"2006.01.02 15:04:05",
+	"2006.01.02T15:04:05",
+	"2006.01.02",
+}
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// isValidIdentifier 判断是否为合法的 DolphinDB 标识符，用于拼接脚本中的表名、列名
+func isValidIdentifier(name string) bool {
+	return identifierRegexp.MatchString(name)
+}
+
+type streamingReplayModel struct {
+	// 分布式数据库路径，例如 dfs://StockDB，为空时 Table 为内存表或共享表
+	Database   string `json:"database,omitempty"`
+	Table      string `json:"table"`
+	TimeColumn string `json:"timeColumn"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	// 回放速度，1 为原速，大于 1 为加速
+	Speed float64 `json:"speed,omitempty"`
+	// 每批读取的数据时间跨度，单位毫秒
+	BatchMs int64 `json:"batchMs,omitempty"`
+}
+
+func parseDDBTime(s string) (time.Time, error) {
+	for _, layout := range ddbTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s", s)
+}
+
+// tableRef 返回脚本中引用这张表的表达式，database 为空时为内存表或共享表
+func tableRef(database string, table string) string {
+	if database == "" {
+		return table
+	}
+	return fmt.Sprintf("loadTable(%s, %s)", strconv.Quote(database), strconv.Quote(table))
+}
+
+// batchScript 生成读取 [from, to) 之间数据的脚本
+func (r streamingReplayModel) batchScript(from time.Time, to time.Time) string {
+	return fmt.Sprintf(
+		"select * from %s where %s >= %s and %s < %s order by %s",
+		tableRef(r.Database, r.Table),
+		r.TimeColumn, from.Format(ddbTimestampLayout),
+		r.TimeColumn, to.Format(ddbTimestampLayout),
+		r.TimeColumn,
+	)
+}
+
+// replayPlan 是校验后的回放参数
+type replayPlan struct {
+	from  time.Time
+	to    time.Time
+	speed float64
+	batch time.Duration
+}
+
+// plan 校验回放定义并填充默认值
+func (r streamingReplayModel) plan() (replayPlan, error) {
+	if !isValidIdentifier(r.Table) || !isValidIdentifier(r.TimeColumn) {
+		return replayPlan{}, errors.New("replay table and time column must be valid identifiers")
+	}
+	from, err := parseDDBTime(r.From)
+	if err != nil {
+		return replayPlan{}, err
+	}
+	to, err := parseDDBTime(r.To)
+	if err != nil {
+		return replayPlan{}, err
+	}
+	if !from.Before(to) {
+		return replayPlan{}, errors.New("replay start time must be before end time")
+	}
+	speed := r.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	if speed < 0 {
+		return replayPlan{}, errors.New("replay speed must be greater than 0")
+	}
+	batch := time.Duration(r.BatchMs) * time.Millisecond
+	if batch <= 0 {
+		batch = defaultReplayBatch
+	}
+	return replayPlan{from: from, to: to, speed: speed, batch: batch}, nil
+}
+
+// batches 把 [from, to) 按 batch 切分为多段，最后一段截止到 to
+func (p replayPlan) batches() [][2]time.Time {
+	var result [][2]time.Time
+	for cursor := p.from; cursor.Before(p.to); cursor = cursor.Add(p.batch) {
+		end := cursor.Add(p.batch)
+		if end.After(p.to) {
+			end = p.to
+		}
+		result = append(result, [2]time.Time{cursor, end})
+	}
+	return result
+}
+
+// target 返回回放从 start 开始时，数据时间 t 对应的推送时间
+func (p replayPlan) target(start time.Time, t time.Time) time.Time {
+	return start.Add(time.Duration(float64(t.Sub(p.from)) / p.speed))
+}
+
+// runReplay 从历史数据中按原始节奏（或加速）读取数据并推送到 Grafana Live 的 channel
+func runReplay(ctx context.Context, replay *streamingReplayModel, readOnly readOnlySettingsModel, uid string, config db.DBConfig, opts db.TransformOptions, framename string, frames *streamFrameSender) error {
+	if replay == nil {
+		return errors.New("replay streaming mode requires a replay definition")
+	}
+	plan, err := replay.plan()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	target := func(t time.Time) time.Time {
+		return plan.target(start, t)
+	}
+
+	for _, b := range plan.batches() {
+		cursor, end := b[0], b[1]
+
+		script := replay.batchScript(cursor, end)
+		if err := readOnly.checkScript(script); err != nil {
+			return err
+		}
+		df, err := db.RunSimpleScript(script, uid, config)
+		if err != nil {
+			return fmt.Errorf("replay query failed: %w", err)
+		}
+		frame, err := db.TransformDataFormWithOptions(df, framename, opts)
+		if err != nil {
+			return fmt.Errorf("replay transform failed: %w", err)
+		}
+		timeField, _ := frame.FieldByName(replay.TimeColumn)
+		if timeField == nil {
+			return fmt.Errorf("time column %s is not in the replay result", replay.TimeColumn)
+		}
+		if timeField.Len() == 0 {
+			// 这一段没有数据，也要按节奏等待，保持回放时间和数据时间一致
+			if err := sleepUntil(ctx, target(end)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rowTime := func(i int) time.Time {
+			if t, ok := toTime(timeField.At(i)); ok {
+				return t
+			}
+			return cursor
+		}
+		for i := 0; i < timeField.Len(); {
+			if err := sleepUntil(ctx, target(rowTime(i))); err != nil {
+				return err
+			}
+			limit := time.Now().Add(replayTick)
+			out := frame.EmptyCopy()
+			for ; i < timeField.Len() && target(rowTime(i)).Before(limit); i++ {
+				out.AppendRow(frame.RowCopy(i)...)
+			}
+			frames.Send(out)
+		}
+	}
+
+	log.DefaultLogger.Info("Replay finished", "table", replay.Table)
+	frames.status.addNotice(data.NoticeSeverityInfo, fmt.Sprintf("replay of %s from %s to %s finished", replay.Table, replay.From, replay.To))
+	frames.Flush(framename)
+
+	// 回放结束后保持 channel，直到面板取消订阅，避免 Grafana 重新开始回放
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func toTime(v interface{}) (time.Time, bool) {
+	switch val := v.(type) {
+	case *time.Time:
+		if val != nil {
+			return *val, true
+		}
+	case time.Time:
+		return val, true
+	}
+	return time.Time{}, false
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
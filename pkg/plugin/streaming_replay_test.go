@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayPlanBatches(t *testing.T) {
+	r := streamingReplayModel{Table: "trades", TimeColumn: "ts", From: "2024.01.02 09:30:00", To: "2024.01.02 09:30:25", BatchMs: 10000}
+	plan, err := r.plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	batches := plan.batches()
+	want := [][2]time.Time{
+		{base, base.Add(10 * time.Second)},
+		{base.Add(10 * time.Second), base.Add(20 * time.Second)},
+		{base.Add(20 * time.Second), base.Add(25 * time.Second)},
+	}
+	if len(batches) != len(want) {
+		t.Fatalf("expected %d batches, got %v", len(want), batches)
+	}
+	for i := range want {
+		if !batches[i][0].Equal(want[i][0]) || !batches[i][1].Equal(want[i][1]) {
+			t.Fatalf("batch %d: expected %v, got %v", i, want[i], batches[i])
+		}
+	}
+	if got := r.batchScript(batches[2][0], batches[2][1]); got != "select * from trades where ts >= 2024.01.02T09:30:20.000 and ts < 2024.01.02T09:30:25.000 order by ts" {
+		t.Fatalf("unexpected script %s", got)
+	}
+
+	r.BatchMs = 0
+	if plan, err = r.plan(); err != nil || plan.batch != defaultReplayBatch || len(plan.batches()) != 1 {
+		t.Fatalf("expected one default batch, got %v %v", plan, err)
+	}
+}
+
+func TestReplayPlanTarget(t *testing.T) {
+	r := streamingReplayModel{Table: "trades", TimeColumn: "ts", From: "2024.01.02 09:30:00", To: "2024.01.02 10:30:00"}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := time.Date(2024, 1, 2, 9, 30, 10, 0, time.UTC)
+
+	plan, err := r.plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.target(start, data); !got.Equal(start.Add(10 * time.Second)) {
+		t.Fatalf("speed 1 should keep the original pace, got %v", got)
+	}
+
+	r.Speed = 4
+	if plan, err = r.plan(); err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.target(start, data); !got.Equal(start.Add(2500 * time.Millisecond)) {
+		t.Fatalf("speed 4 should replay 4 times faster, got %v", got)
+	}
+	if got := plan.target(start, plan.from); !got.Equal(start) {
+		t.Fatalf("the first row is pushed immediately, got %v", got)
+	}
+}
+
+func TestReplayPlanInvalid(t *testing.T) {
+	valid := streamingReplayModel{Table: "trades", TimeColumn: "ts", From: "2024.01.02", To: "2024.01.03"}
+	cases := map[string]func(r *streamingReplayModel){
+		"table":   func(r *streamingReplayModel) { r.Table = "trades; dropDatabase" },
+		"column":  func(r *streamingReplayModel) { r.TimeColumn = "1ts" },
+		"from":    func(r *streamingReplayModel) { r.From = "yesterday" },
+		"reverse": func(r *streamingReplayModel) { r.From, r.To = r.To, r.From },
+		"speed":   func(r *streamingReplayModel) { r.Speed = -1 },
+	}
+	for name, modify := range cases {
+		r := valid
+		modify(&r)
+		if _, err := r.plan(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := valid.plan(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseDDBTime(t *testing.T) {
+	cases := map[string]time.Time{
+		"2024.01.02 09:30:00.123": time.Date(2024, 1, 2, 9, 30, 0, 123e6, time.UTC),
+		"2024.01.02T09:30:00.123": time.Date(2024, 1, 2, 9, 30, 0, 123e6, time.UTC),
+		"2024.01.02 09:30:00":     time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC),
+		"2024.01.02T09:30:00":     time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC),
+		"2024.01.02":              time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	for s, want := range cases {
+		got, err := parseDDBTime(s)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("%s: expected %v, got %v %v", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"", "2024-01-02 09:30:00", "2024.13.02", "09:30:00"} {
+		if _, err := parseDDBTime(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestTableRef(t *testing.T) {
+	if got := tableRef("", "trades"); got != "trades" {
+		t.Fatalf("unexpected ref %s", got)
+	}
+	if got := tableRef("dfs://StockDB", "trades"); got != `loadTable("dfs://StockDB", "trades")` {
+		t.Fatalf("unexpected ref %s", got)
+	}
+	if got := tableRef(`dfs://a") ; dropDatabase("dfs://b`, "trades"); got != `loadTable("dfs://a\") ; dropDatabase(\"dfs://b", "trades")` {
+		t.Fatalf("database path must be quoted, got %s", got)
+	}
+
+	for _, name := range []string{"trades", "_tmp", "Trades2024", "a_b_c"} {
+		if !isValidIdentifier(name) {
+			t.Errorf("%s should be valid", name)
+		}
+	}
+	for _, name := range []string{"", "1trades", "trades;", "trades table", "loadTable(\"x\")", "交易", "a.b"} {
+		if isValidIdentifier(name) {
+			t.Errorf("%q should be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// 流数据消息的缓冲区大小，缓冲区满了之后的消息会被丢弃并报告给面板
+	streamBufferSize = 1024
+	// 检查订阅是否还在发布端的间隔
+	streamProbeInterval = 10 * time.Second
+	// 没有新数据时推送 notice 的间隔
+	streamStatusInterval = time.Second
+)
+
+// streamStatus 收集订阅过程中的异常（丢消息、重连、表结构不一致），以 frame notice 的形式推送给面板
+// DoEvent 在 API 的 goroutine 中调用，这里的方法都需要并发安全
+type streamStatus struct {
+	mu      sync.Mutex
+	notices []data.Notice
+	// 已经报告过结构问题的列，每列只报告一次
+	reportedColumns map[string]bool
+	reportedSize    bool
+
+	dropped int64
+	// 每张流数据表最后一条消息的 offset，不同表的 offset 互不相关
+	lastOffsets map[string]int64
+}
+
+func newStreamStatus() *streamStatus {
+	return &streamStatus{
+		reportedColumns: make(map[string]bool),
+		lastOffsets:     make(map[string]int64),
+	}
+}
+
+func (s *streamStatus) addNotice(severity data.NoticeSeverity, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notices = append(s.notices, data.Notice{Severity: severity, Text: text})
+}
+
+// messageDropped 记录因为面板来不及消费而丢弃的消息
+func (s *streamStatus) messageDropped(n int64) {
+	atomic.AddInt64(&s.dropped, n)
+}
+
+// checkOffset 根据一张表的消息的 offset 判断是否有消息丢失，比如重连期间
+func (s *streamStatus) checkOffset(table string, offset int64) {
+	s.mu.Lock()
+	last, ok := s.lastOffsets[table]
+	s.lastOffsets[table] = offset
+	s.mu.Unlock()
+	if ok && offset > last+1 {
+		s.messageDropped(offset - last - 1)
+	}
+}
+
+// schemaMismatch 报告消息中缺失或无法转换的列
+func (s *streamStatus) schemaMismatch(column string, reason string) {
+	s.mu.Lock()
+	if s.reportedColumns[column] {
+		s.mu.Unlock()
+		return
+	}
+	s.reportedColumns[column] = true
+	s.mu.Unlock()
+	s.addNotice(data.NoticeSeverityWarning, fmt.Sprintf("column %s %s", column, reason))
+}
+
+// sizeMismatch 报告消息列数和表结构不一致，只报告一次
+func (s *streamStatus) sizeMismatch(got int, want int) {
+	s.mu.Lock()
+	if s.reportedSize {
+		s.mu.Unlock()
+		return
+	}
+	s.reportedSize = true
+	s.mu.Unlock()
+	s.addNotice(data.NoticeSeverityWarning, fmt.Sprintf("message has %d columns but the streaming table has %d, the table schema may have changed", got, want))
+}
+
+// takeNotices 取出所有待推送的 notice
+func (s *streamStatus) takeNotices() []data.Notice {
+	s.mu.Lock()
+	notices := s.notices
+	s.notices = nil
+	s.mu.Unlock()
+
+	if dropped := atomic.SwapInt64(&s.dropped, 0); dropped > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("%d streaming messages were dropped", dropped),
+		})
+	}
+	return notices
+}
+
+// pending 判断是否有待推送的 notice
+func (s *streamStatus) pending() bool {
+	s.mu.Lock()
+	n := len(s.notices)
+	s.mu.Unlock()
+	return n > 0 || atomic.LoadInt64(&s.dropped) > 0
+}
+
+// streamFrameSender 推送 frame 时附带上待推送的 notice，并记录最后推送的 frame 的结构
+type streamFrameSender struct {
+	sender *backend.StreamSender
+	status *streamStatus
+	last   *data.Frame
+}
+
+// Send 推送一个 frame，frame 为 nil 时什么也不做
+func (s *streamFrameSender) Send(frame *data.Frame) {
+	if frame == nil {
+		return
+	}
+	if notices := s.status.takeNotices(); len(notices) > 0 {
+		frame.AppendNotices(notices...)
+	}
+	s.last = frame
+	if err := s.sender.SendFrame(frame, data.IncludeAll); err != nil {
+		log.DefaultLogger.Error("Failed send frame", "error", err)
+	}
+}
+
+// Flush 在没有新数据时推送一个不含数据的 frame，只用来携带 notice
+// 沿用最后推送的 frame 的结构，避免面板上的 frame 结构来回变化
+func (s *streamFrameSender) Flush(framename string) {
+	if !s.status.pending() {
+		return
+	}
+	frame := data.NewFrame(framename)
+	if s.last != nil {
+		frame = s.last.EmptyCopy()
+	}
+	s.Send(frame)
+}
+
+// subscriptionMonitor 检查这个流的订阅是否还在发布端，把订阅断开和恢复的情况报告给面板
+// API 的 GoroutineClient 不暴露重连的事件，订阅断开后发布端的 pubTables 中就没有这个 action 了，API 重新订阅成功后会再出现
+type subscriptionMonitor struct {
+	action string
+	tables []string
+	status *streamStatus
+	// 每张表连续检查到订阅不在的次数
+	attempts map[string]int
+}
+
+func newSubscriptionMonitor(action string, tables []string, status *streamStatus) *subscriptionMonitor {
+	return &subscriptionMonitor{action: action, tables: tables, status: status, attempts: make(map[string]int)}
+}
+
+// check 根据发布端的订阅列表更新每张表的订阅状态，err 为列出订阅时的错误，这时数据库连不上，订阅也一定断开了
+func (m *subscriptionMonitor) check(published []publishedAction, err error) {
+	for _, table := range m.tables {
+		if err == nil && hasPublishedAction(published, table, m.action) {
+			if n := m.attempts[table]; n > 0 {
+				m.status.addNotice(data.NoticeSeverityInfo, fmt.Sprintf("subscription to %s restored after %d checks", table, n))
+				m.attempts[table] = 0
+			}
+			continue
+		}
+		m.attempts[table]++
+		reason := "the publisher no longer lists this subscription"
+		if err != nil {
+			reason = err.Error()
+		}
+		m.status.addNotice(data.NoticeSeverityWarning, fmt.Sprintf("subscription to %s lost, reconnecting (check %d): %s", table, m.attempts[table], reason))
+	}
+}
+
+func hasPublishedAction(published []publishedAction, table string, action string) bool {
+	for _, p := range published {
+		if p.table == table && p.action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// watchSubscriptions 定时从发布端列出订阅，检查这个流的订阅
+// 订阅本身的重连由 API 完成，这里只负责让用户看得到
+func watchSubscriptions(ctx context.Context, monitor *subscriptionMonitor, uuid string, config db.DBConfig) {
+	ticker := time.NewTicker(streamProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			monitor.check(listPublishedActions(uuid, config))
+		}
+	}
+}
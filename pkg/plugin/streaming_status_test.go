@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func noticeTexts(notices []data.Notice) string {
+	texts := make([]string, len(notices))
+	for i, n := range notices {
+		texts[i] = n.Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+func TestStreamStatusDropped(t *testing.T) {
+	s := newStreamStatus()
+	if s.pending() || len(s.takeNotices()) != 0 {
+		t.Fatal("a new status has nothing to report")
+	}
+
+	s.messageDropped(2)
+	// 第一条消息没有可以比较的 offset，之后每张表的 offset 分别比较
+	s.checkOffset("a", 10)
+	s.checkOffset("b", 100)
+	s.checkOffset("a", 11)
+	s.checkOffset("b", 101)
+	s.checkOffset("a", 15)
+	if !s.pending() {
+		t.Fatal("expected dropped messages to be pending")
+	}
+	notices := s.takeNotices()
+	if len(notices) != 1 || notices[0].Text != "5 streaming messages were dropped" || notices[0].Severity != data.NoticeSeverityWarning {
+		t.Fatalf("unexpected notices %v", notices)
+	}
+	if s.pending() || len(s.takeNotices()) != 0 {
+		t.Fatal("notices must be cleared after they are taken")
+	}
+}
+
+func TestStreamStatusSchema(t *testing.T) {
+	s := newStreamStatus()
+	s.schemaMismatch("price", "is missing from the streaming message")
+	s.schemaMismatch("price", "is missing from the streaming message")
+	s.sizeMismatch(3, 4)
+	s.sizeMismatch(3, 4)
+	got := noticeTexts(s.takeNotices())
+	want := "column price is missing from the streaming message\nmessage has 3 columns but the streaming table has 4, the table schema may have changed"
+	if got != want {
+		t.Fatalf("each problem must be reported once, got\n%s", got)
+	}
+}
+
+func TestSubscriptionMonitor(t *testing.T) {
+	s := newStreamStatus()
+	m := newSubscriptionMonitor("grafana_x", []string{"trades", "quotes"}, s)
+	both := []publishedAction{
+		{table: "trades", subscriber: "10.0.0.1:8101", action: "grafana_x"},
+		{table: "quotes", subscriber: "10.0.0.1:8101", action: "grafana_x"},
+		{table: "quotes", subscriber: "10.0.0.2:8101", action: "other"},
+	}
+
+	m.check(both, nil)
+	if s.pending() {
+		t.Fatalf("no notice while the subscriptions are present, got %v", s.takeNotices())
+	}
+
+	// 发布端不再列出 trades 的订阅，之后数据库也连不上
+	m.check(both[1:], nil)
+	m.check(nil, errors.New("connection refused"))
+	got := noticeTexts(s.takeNotices())
+	want := strings.Join([]string{
+		"subscription to trades lost, reconnecting (check 1): the publisher no longer lists this subscription",
+		"subscription to trades lost, reconnecting (check 2): connection refused",
+		"subscription to quotes lost, reconnecting (check 1): connection refused",
+	}, "\n")
+	if got != want {
+		t.Fatalf("unexpected notices\n%s", got)
+	}
+
+	m.check(both, nil)
+	got = noticeTexts(s.takeNotices())
+	want = "subscription to trades restored after 2 checks\nsubscription to quotes restored after 1 checks"
+	if got != want {
+		t.Fatalf("unexpected notices\n%s", got)
+	}
+	m.check(both, nil)
+	if s.pending() {
+		t.Fatal("a restored subscription is reported once")
+	}
+}
@@ -0,0 +1,401 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 窗口类型
+const (
+	// 滚动窗口，窗口之间不重叠
+	windowTypeTumbling = "tumbling"
+	// 滑动窗口，每隔 SlideMs 计算一次最近 SizeMs 内的数据
+	windowTypeSliding = "sliding"
+)
+
+// 支持的聚合函数
+const (
+	aggCount = "count"
+	aggSum   = "sum"
+	aggAvg   = "avg"
+	aggMin   = "min"
+	aggMax   = "max"
+	aggFirst = "first"
+	aggLast  = "last"
+)
+
+type streamingWindowModel struct {
+	Type    string `json:"type"`
+	SizeMs  int64  `json:"sizeMs"`
+	SlideMs int64  `json:"slideMs,omitempty"`
+	// 事件时间列，为空时使用数据到达插件的时间
+	TimeColumn   string                      `json:"timeColumn,omitempty"`
+	GroupBy      string                      `json:"groupBy,omitempty"`
+	Aggregations []streamingAggregationModel `json:"aggregations"`
+}
+
+type streamingAggregationModel struct {
+	Column string `json:"column,omitempty"`
+	Func   string `json:"func"`
+	Alias  string `json:"alias,omitempty"`
+}
+
+func (a streamingAggregationModel) name() string {
+	if a.Alias != "" {
+		return a.Alias
+	}
+	if a.Column == "" {
+		return a.Func
+	}
+	return fmt.Sprintf("%s_%s", a.Func, a.Column)
+}
+
+// validate 检查窗口配置，columns 为流数据表的列名
+func (w *streamingWindowModel) validate(columns []string) error {
+	switch w.Type {
+	case windowTypeTumbling, "":
+		w.Type = windowTypeTumbling
+		w.SlideMs = w.SizeMs
+	case windowTypeSliding:
+		if w.SlideMs <= 0 || w.SlideMs > w.SizeMs {
+			return errors.New("sliding window requires 0 < slideMs <= sizeMs")
+		}
+	default:
+		return fmt.Errorf("unsupported window type %s", w.Type)
+	}
+	if w.SizeMs <= 0 {
+		return errors.New("window size must be greater than 0")
+	}
+	if len(w.Aggregations) == 0 {
+		return errors.New("window requires at least one aggregation")
+	}
+	for _, col := range []string{w.TimeColumn, w.GroupBy} {
+		if col != "" && !containsString(columns, col) {
+			return fmt.Errorf("column %s does not exist in streaming table", col)
+		}
+	}
+	for _, agg := range w.Aggregations {
+		switch agg.Func {
+		case aggCount:
+		case aggSum, aggAvg, aggMin, aggMax, aggFirst, aggLast:
+			if agg.Column == "" {
+				return fmt.Errorf("aggregation %s requires a column", agg.Func)
+			}
+		default:
+			return fmt.Errorf("unsupported aggregation %s", agg.Func)
+		}
+		if agg.Column != "" && !containsString(columns, agg.Column) {
+			return fmt.Errorf("column %s does not exist in streaming table", agg.Column)
+		}
+	}
+	return nil
+}
+
+type windowRow struct {
+	ts     time.Time
+	group  string
+	values map[string]*data.Field
+}
+
+// windowAggregator 在插件内对流数据做窗口聚合，只推送窗口结果
+// 窗口的结束时间按 slide 对齐，水位线（事件时间的最大值或当前时间）越过窗口结束时间时输出该窗口
+type windowAggregator struct {
+	model   streamingWindowModel
+	size    time.Duration
+	slide   time.Duration
+	rows    []windowRow
+	nextEnd time.Time
+	// 事件时间模式下已见到的最大事件时间
+	watermark time.Time
+	// first/last 需要保持原始列的类型
+	fieldTypes map[string]data.FieldType
+}
+
+func newWindowAggregator(model streamingWindowModel) *windowAggregator {
+	return &windowAggregator{
+		model:      model,
+		size:       time.Duration(model.SizeMs) * time.Millisecond,
+		slide:      time.Duration(model.SlideMs) * time.Millisecond,
+		fieldTypes: make(map[string]data.FieldType),
+	}
+}
+
+// eventTime 为 true 时窗口由数据中的时间列驱动，否则由到达时间驱动
+func (w *windowAggregator) eventTime() bool {
+	return w.model.TimeColumn != ""
+}
+
+// Add 加入一行流数据，arrival 为数据到达的时间
+func (w *windowAggregator) Add(row []*data.Field, arrival time.Time) {
+	values := make(map[string]*data.Field, len(row))
+	for _, f := range row {
+		values[f.Name] = f
+		if _, ok := w.fieldTypes[f.Name]; !ok {
+			w.fieldTypes[f.Name] = f.Type()
+		}
+	}
+
+	ts := arrival
+	if w.eventTime() {
+		t, ok := fieldTime(values[w.model.TimeColumn])
+		// 没有事件时间的行无法归入窗口
+		if !ok {
+			return
+		}
+		ts = t
+		if ts.After(w.watermark) {
+			w.watermark = ts
+		}
+	}
+
+	// 已经输出过的窗口不再接收迟到的数据
+	if !w.nextEnd.IsZero() && ts.Before(w.nextEnd.Add(-w.size)) {
+		return
+	}
+
+	group := ""
+	if w.model.GroupBy != "" {
+		if f, ok := values[w.model.GroupBy]; ok && f.Len() > 0 {
+			group = formatFieldValue(f.At(0))
+		} else {
+			group = "null"
+		}
+	}
+
+	w.rows = append(w.rows, windowRow{ts: ts, group: group, values: values})
+	if w.nextEnd.IsZero() {
+		w.nextEnd = ts.Truncate(w.slide).Add(w.slide)
+	}
+}
+
+// Advance 输出所有结束时间不晚于水位线的窗口，没有窗口结束时返回 nil
+func (w *windowAggregator) Advance(watermark time.Time, framename string) *data.Frame {
+	var frame *data.Frame
+	for !w.nextEnd.IsZero() && !watermark.Before(w.nextEnd) {
+		// 流数据中断或水位线跳跃时直接跳到包含最早的缓存数据的窗口，不逐个滑过没有数据的窗口
+		if end := w.firstWindowEnd(); end.After(w.nextEnd) {
+			w.nextEnd = end
+			continue
+		}
+		start := w.nextEnd.Add(-w.size)
+		var inWindow []windowRow
+		for _, r := range w.rows {
+			if !r.ts.Before(start) && r.ts.Before(w.nextEnd) {
+				inWindow = append(inWindow, r)
+			}
+		}
+		if len(inWindow) > 0 {
+			if frame == nil {
+				frame = w.newFrame(framename)
+			}
+			w.appendWindow(frame, w.nextEnd, inWindow)
+		}
+
+		w.nextEnd = w.nextEnd.Add(w.slide)
+
+		// 丢弃之后的窗口都用不到的行
+		keepFrom := w.nextEnd.Add(-w.size)
+		kept := w.rows[:0]
+		for _, r := range w.rows {
+			if !r.ts.Before(keepFrom) {
+				kept = append(kept, r)
+			}
+		}
+		w.rows = kept
+		if len(w.rows) == 0 {
+			// 没有数据了，等下一行数据到来再重新对齐窗口
+			w.nextEnd = time.Time{}
+		}
+	}
+	return frame
+}
+
+// firstWindowEnd 返回包含最早的缓存数据的第一个窗口的结束时间
+func (w *windowAggregator) firstWindowEnd() time.Time {
+	if len(w.rows) == 0 {
+		return time.Time{}
+	}
+	earliest := w.rows[0].ts
+	for _, r := range w.rows[1:] {
+		if r.ts.Before(earliest) {
+			earliest = r.ts
+		}
+	}
+	return earliest.Truncate(w.slide).Add(w.slide)
+}
+
+func (w *windowAggregator) newFrame(framename string) *data.Frame {
+	frame := data.NewFrame(framename, data.NewField("time", nil, []time.Time{}))
+	if w.model.GroupBy != "" {
+		frame.Fields = append(frame.Fields, data.NewField(w.model.GroupBy, nil, []*string{}))
+	}
+	for _, agg := range w.model.Aggregations {
+		var field *data.Field
+		switch agg.Func {
+		case aggCount:
+			field = data.NewField(agg.name(), nil, []int64{})
+		case aggFirst, aggLast:
+			ft, ok := w.fieldTypes[agg.Column]
+			if !ok {
+				ft = data.FieldTypeNullableString
+			}
+			field = data.NewFieldFromFieldType(ft.NullableType(), 0)
+			field.Name = agg.name()
+		default:
+			field = data.NewField(agg.name(), nil, []*float64{})
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+	return frame
+}
+
+// appendWindow 把一个窗口的聚合结果按分组追加到 frame 中
+func (w *windowAggregator) appendWindow(frame *data.Frame, end time.Time, rows []windowRow) {
+	var groups []string
+	grouped := make(map[string][]windowRow)
+	for _, r := range rows {
+		if _, ok := grouped[r.group]; !ok {
+			groups = append(groups, r.group)
+		}
+		grouped[r.group] = append(grouped[r.group], r)
+	}
+
+	for _, g := range groups {
+		idx := 0
+		frame.Fields[idx].Append(end)
+		idx++
+		if w.model.GroupBy != "" {
+			group := g
+			frame.Fields[idx].Append(&group)
+			idx++
+		}
+		for _, agg := range w.model.Aggregations {
+			field := frame.Fields[idx]
+			idx++
+			switch agg.Func {
+			case aggCount:
+				field.Append(countRows(grouped[g], agg.Column))
+			case aggFirst, aggLast:
+				field.Append(pickRow(grouped[g], agg, field.Type()))
+			default:
+				field.Append(aggregateNumeric(grouped[g], agg))
+			}
+		}
+	}
+}
+
+func countRows(rows []windowRow, column string) int64 {
+	if column == "" {
+		return int64(len(rows))
+	}
+	var n int64
+	for _, r := range rows {
+		if f, ok := r.values[column]; ok && f.Len() > 0 && formatFieldValue(f.At(0)) != "null" {
+			n++
+		}
+	}
+	return n
+}
+
+// pickRow 返回窗口中第一个或最后一个非空值
+func pickRow(rows []windowRow, agg streamingAggregationModel, ft data.FieldType) interface{} {
+	empty := data.NewFieldFromFieldType(ft, 1).At(0)
+	for i := range rows {
+		r := rows[i]
+		if agg.Func == aggLast {
+			r = rows[len(rows)-1-i]
+		}
+		f, ok := r.values[agg.Column]
+		if !ok || f.Len() == 0 || f.Type() != ft || formatFieldValue(f.At(0)) == "null" {
+			continue
+		}
+		return f.At(0)
+	}
+	return empty
+}
+
+func aggregateNumeric(rows []windowRow, agg streamingAggregationModel) *float64 {
+	var result float64
+	n := 0
+	for _, r := range rows {
+		f, ok := r.values[agg.Column]
+		if !ok || f.Len() == 0 {
+			continue
+		}
+		v, ok := toFloat64(f.At(0))
+		if !ok {
+			continue
+		}
+		switch {
+		case n == 0:
+			result = v
+		case agg.Func == aggSum || agg.Func == aggAvg:
+			result += v
+		case agg.Func == aggMin && v < result:
+			result = v
+		case agg.Func == aggMax && v > result:
+			result = v
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	if agg.Func == aggAvg {
+		result /= float64(n)
+	}
+	return &result
+}
+
+// toFloat64 把 field 中的数值（通常是指针）转换成 float64
+func toFloat64(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case *float64:
+		if val != nil {
+			return *val, true
+		}
+	case *float32:
+		if val != nil {
+			return float64(*val), true
+		}
+	case *int64:
+		if val != nil {
+			return float64(*val), true
+		}
+	case *int32:
+		if val != nil {
+			return float64(*val), true
+		}
+	case *int16:
+		if val != nil {
+			return float64(*val), true
+		}
+	case *int8:
+		if val != nil {
+			return float64(*val), true
+		}
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int16:
+		return float64(val), true
+	case int8:
+		return float64(val), true
+	}
+	return 0, false
+}
+
+func fieldTime(f *data.Field) (time.Time, bool) {
+	if f == nil || f.Len() == 0 {
+		return time.Time{}, false
+	}
+	return toTime(f.At(0))
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func windowRowFields(ts time.Time, sym string, price float64) []*data.Field {
+	return []*data.Field{
+		data.NewField("ts", nil, []*time.Time{&ts}),
+		data.NewField("sym", nil, []*string{&sym}),
+		data.NewField("price", nil, []*float64{&price}),
+	}
+}
+
+func TestWindowAggregatorTumbling(t *testing.T) {
+	model := streamingWindowModel{
+		SizeMs:     1000,
+		TimeColumn: "ts",
+		GroupBy:    "sym",
+		Aggregations: []streamingAggregationModel{
+			{Func: aggCount},
+			{Func: aggAvg, Column: "price"},
+			{Func: aggLast, Column: "price"},
+		},
+	}
+	if err := model.validate([]string{"ts", "sym", "price"}); err != nil {
+		t.Fatal(err)
+	}
+	agg := newWindowAggregator(model)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	agg.Add(windowRowFields(base.Add(100*time.Millisecond), "A", 1), base)
+	agg.Add(windowRowFields(base.Add(200*time.Millisecond), "A", 3), base)
+	agg.Add(windowRowFields(base.Add(300*time.Millisecond), "B", 5), base)
+	if frame := agg.Advance(agg.watermark, "w"); frame != nil {
+		t.Fatal("window must not close before the watermark passes its end")
+	}
+
+	agg.Add(windowRowFields(base.Add(1100*time.Millisecond), "A", 7), base)
+	frame := agg.Advance(agg.watermark, "w")
+	if frame == nil {
+		t.Fatal("expected the first window to close")
+	}
+	rows, _ := frame.RowLen()
+	if rows != 2 {
+		t.Fatalf("expected one row per group, got %d", rows)
+	}
+	if end := frame.Fields[0].At(0).(time.Time); !end.Equal(base.Add(time.Second)) {
+		t.Fatalf("unexpected window end %v", end)
+	}
+	if count := frame.Fields[2].At(0).(int64); count != 2 {
+		t.Fatalf("expected count 2, got %d", count)
+	}
+	if avg := *frame.Fields[3].At(0).(*float64); avg != 2 {
+		t.Fatalf("expected avg 2, got %v", avg)
+	}
+	if last := *frame.Fields[4].At(0).(*float64); last != 3 {
+		t.Fatalf("expected last 3, got %v", last)
+	}
+}
+
+func TestWindowAggregatorWatermarkJump(t *testing.T) {
+	model := streamingWindowModel{
+		Type:         windowTypeSliding,
+		SizeMs:       3,
+		SlideMs:      1,
+		TimeColumn:   "ts",
+		Aggregations: []streamingAggregationModel{{Func: aggCount}},
+	}
+	if err := model.validate([]string{"ts", "sym", "price"}); err != nil {
+		t.Fatal(err)
+	}
+	agg := newWindowAggregator(model)
+
+	// 一行迟到数据的时钟让水位线向后跳了 30 天，1 毫秒的滑动步长不能逐个滑过中间的窗口
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	agg.Add(windowRowFields(base, "A", 1), base)
+	agg.Add(windowRowFields(base.Add(30*24*time.Hour), "A", 2), base)
+
+	done := make(chan *data.Frame)
+	go func() { done <- agg.Advance(agg.watermark, "w") }()
+	var frame *data.Frame
+	select {
+	case frame = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Advance did not skip the empty windows")
+	}
+	// 第一行属于 3 个滑动窗口，第二行所在的窗口还没有结束
+	if rows, _ := frame.RowLen(); rows != 3 {
+		t.Fatalf("expected 3 windows, got %d", rows)
+	}
+	if end := frame.Fields[0].At(2).(time.Time); !end.Equal(base.Add(3 * time.Millisecond)) {
+		t.Fatalf("unexpected last window end %v", end)
+	}
+	if len(agg.rows) != 1 || !agg.nextEnd.Equal(base.Add(30*24*time.Hour+time.Millisecond)) {
+		t.Fatalf("expected the later row to stay buffered, next window ends at %v", agg.nextEnd)
+	}
+}
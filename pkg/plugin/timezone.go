@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// Grafana 的运行环境不一定有时区数据库，按面板时区转换时间需要
+	_ "time/tzdata"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// DolphinDB 的时间类型不带时区。前端按面板时区的墙上时间展开 $timeFilter，并把查询结果中的时间当作面板时区的墙上时间显示，
+// Go API 把这些时间解码为 UTC 的 time.Time。所以后端用墙上时间标记为 UTC 的 time.Time 表示 DolphinDB 中的时间，
+// 查询的时间范围等真实的时刻要先用 ddbTime 转换再和查询结果比较或者格式化为字面量
+
+// queryLocation 返回前端发送的面板时区，为空时（告警等不经过前端的查询）使用 UTC
+func queryLocation(name string) (*time.Location, error) {
+	if name == "" || strings.EqualFold(name, "utc") {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %s: %w", name, err)
+	}
+	return loc, nil
+}
+
+// ddbTime 把时刻转换为 loc 中的墙上时间，标记为 UTC
+func ddbTime(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	y, mon, d := local.Date()
+	h, m, s := local.Clock()
+	return time.Date(y, mon, d, h, m, s, local.Nanosecond(), time.UTC)
+}
+
+// ddbTimeRange 把查询的时间范围转换为 loc 中的墙上时间
+func ddbTimeRange(tr backend.TimeRange, loc *time.Location) backend.TimeRange {
+	return backend.TimeRange{From: ddbTime(tr.From, loc), To: ddbTime(tr.To, loc)}
+}
@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestDDBTimeRange(t *testing.T) {
+	loc, err := queryLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 面板时区 UTC+8 的 2024.01.02 00:00 到 06:00
+	tr := ddbTimeRange(backend.TimeRange{
+		From: time.Date(2024, 1, 1, 16, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC),
+	}, loc)
+	if !tr.From.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) || !tr.To.Equal(time.Date(2024, 1, 2, 6, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected range %v", tr)
+	}
+	if got := expandMacros("$__timeFilter", tr, time.Minute); got != "pair(2024.01.02T00:00:00.000, 2024.01.02T06:00:00.000)" {
+		t.Fatalf("unexpected script %s", got)
+	}
+
+	// 按天拆分时边界为面板时区的零点，而不是 UTC 的零点
+	ranges, err := splitTimeRange(ddbTimeRange(backend.TimeRange{
+		From: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC),
+	}, loc), chunkQueryModel{Align: chunkAlignDay}, 0)
+	if err != nil || len(ranges) != 2 || !ranges[1].From.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected daily chunks %v %v", ranges, err)
+	}
+
+	if loc, err := queryLocation(""); err != nil || loc != time.UTC {
+		t.Fatalf("expected UTC for an empty timezone, got %v %v", loc, err)
+	}
+	if _, err := queryLocation("Mars/Olympus"); err == nil {
+		t.Fatal("expected an error for an unknown timezone")
+	}
+}
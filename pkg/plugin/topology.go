@@ -0,0 +1,267 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dolphin-db/dolphindb-datasource/pkg/db"
+	"github.com/dolphindb/api-go/v3/model"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// 流数据拓扑的 QueryType，返回节点图需要的 nodes 和 edges 两个 frame
+const queryTypeStreamingTopology = "streamingTopology"
+
+// getStreamEngineStat 返回按引擎类型分组的字典，这里展开为一张表
+// 不同类型的引擎列不同，只取引擎名、类型和内存占用
+const streamEngineListScript = `stat = getStreamEngineStat()
+engineNames = string[]
+engineTypes = string[]
+engineMemory = long[]
+for (engineType in stat.keys()) {
+	t = stat[engineType]
+	engineNames.append!(string(t.name))
+	engineTypes.append!(take(engineType, size(t)))
+	if ("memoryUsed" in t.columnNames()) engineMemory.append!(long(t.memoryUsed))
+	else engineMemory.append!(take(long(), size(t)))
+}
+table(engineNames as name, engineTypes as engineType, engineMemory as memoryUsed)`
+
+type pubTableRow struct {
+	table      string
+	subscriber string
+	msgOffset  float64
+	actions    []string
+}
+
+type subWorkerRow struct {
+	table          string
+	action         string
+	subscriber     string
+	queueDepth     float64
+	processedCount float64
+}
+
+type engineRow struct {
+	name       string
+	engineType string
+	memoryUsed *float64
+}
+
+type topologyInput struct {
+	pubTables []pubTableRow
+	workers   []subWorkerRow
+	engines   []engineRow
+}
+
+// rateTracker 记录计数器上一次的值，用两次查询之间的差值计算每秒的消息数
+type rateTracker struct {
+	mu      sync.Mutex
+	samples map[string]rateSample
+}
+
+type rateSample struct {
+	value float64
+	at    time.Time
+}
+
+func newRateTracker() *rateTracker {
+	return &rateTracker{samples: make(map[string]rateSample)}
+}
+
+// rate 返回计数器 key 从上次记录到现在的速率，第一次查询或者计数器重置时没有速率
+func (r *rateTracker) rate(key string, value float64, now time.Time) *float64 {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev, ok := r.samples[key]
+	r.samples[key] = rateSample{value: value, at: now}
+	elapsed := now.Sub(prev.at).Seconds()
+	if !ok || elapsed <= 0 || value < prev.value {
+		return nil
+	}
+	rate := (value - prev.value) / elapsed
+	return &rate
+}
+
+// queryTopology 查询发布表、订阅和流计算引擎，生成节点图
+func (d *Datasource) queryTopology(uid string, config db.DBConfig) (data.Frames, error) {
+	var input topologyInput
+
+	df, err := db.RunSimpleScript("getStreamingStat().pubTables", uid, config)
+	if err != nil {
+		return nil, err
+	}
+	if tb, ok := df.(*model.Table); ok {
+		for i := 0; i < tb.Rows(); i++ {
+			actions := strings.Trim(columnString(tb, "actions", i), "[]")
+			row := pubTableRow{
+				table:      columnString(tb, "tableName", i),
+				subscriber: columnString(tb, "subscriber", i),
+				msgOffset:  columnNumber(tb, "msgOffset", i),
+			}
+			for _, action := range strings.Split(actions, ",") {
+				if action = strings.TrimSpace(action); action != "" {
+					row.actions = append(row.actions, action)
+				}
+			}
+			input.pubTables = append(input.pubTables, row)
+		}
+	}
+
+	df, err = db.RunSimpleScript("getStreamingStat().subWorkers", uid, config)
+	if err != nil {
+		return nil, err
+	}
+	if tb, ok := df.(*model.Table); ok {
+		for i := 0; i < tb.Rows(); i++ {
+			// topic 的格式为 host:port:alias/tableName/actionName
+			parts := strings.Split(columnString(tb, "topic", i), "/")
+			if len(parts) < 3 {
+				continue
+			}
+			input.workers = append(input.workers, subWorkerRow{
+				subscriber:     parts[0],
+				table:          parts[len(parts)-2],
+				action:         parts[len(parts)-1],
+				queueDepth:     columnNumber(tb, "queueDepth", i),
+				processedCount: columnNumber(tb, "processedMsgCount", i),
+			})
+		}
+	}
+
+	df, err = db.RunSimpleScript(streamEngineListScript, uid, config)
+	if err != nil {
+		return nil, err
+	}
+	if tb, ok := df.(*model.Table); ok {
+		for i := 0; i < tb.Rows(); i++ {
+			row := engineRow{
+				name:       columnString(tb, "name", i),
+				engineType: columnString(tb, "engineType", i),
+			}
+			if col := tb.GetColumnByName("memoryUsed"); col != nil && !col.IsNull(i) {
+				mem := columnNumber(tb, "memoryUsed", i)
+				row.memoryUsed = &mem
+			}
+			input.engines = append(input.engines, row)
+		}
+	}
+
+	return buildTopologyFrames(input, d.rates, time.Now()), nil
+}
+
+func columnString(tb *model.Table, name string, i int) string {
+	col := tb.GetColumnByName(name)
+	if col == nil || col.IsNull(i) {
+		return ""
+	}
+	return col.Get(i).String()
+}
+
+func columnNumber(tb *model.Table, name string, i int) float64 {
+	f, _ := strconv.ParseFloat(columnString(tb, name, i), 64)
+	return f
+}
+
+// topologyNode 是节点图中的一个节点，mainstat 为每秒消息数，secondarystat 为队列深度或消息偏移
+type topologyNode struct {
+	id            string
+	title         string
+	subtitle      string
+	kind          string
+	mainstat      *float64
+	secondarystat *float64
+}
+
+// buildTopologyFrames 生成节点图的 nodes 和 edges
+// 节点为流数据表、订阅和流计算引擎，订阅的 action 和引擎同名时认为订阅把数据写入了这个引擎
+func buildTopologyFrames(input topologyInput, rates *rateTracker, now time.Time) data.Frames {
+	nodes := make(map[string]*topologyNode)
+	var order []string
+	addNode := func(n *topologyNode) *topologyNode {
+		if existing, ok := nodes[n.id]; ok {
+			return existing
+		}
+		nodes[n.id] = n
+		order = append(order, n.id)
+		return n
+	}
+	edges := make(map[string][2]string)
+	addEdge := func(source string, target string) {
+		edges[source+"->"+target] = [2]string{source, target}
+	}
+
+	tableNode := func(table string) *topologyNode {
+		return addNode(&topologyNode{id: "table:" + table, title: table, subtitle: "stream table", kind: "table"})
+	}
+	subscriptionNode := func(table string, action string, subscriber string) *topologyNode {
+		return addNode(&topologyNode{id: fmt.Sprintf("subscription:%s/%s", table, action), title: action, subtitle: subscriber, kind: "subscription"})
+	}
+
+	for _, p := range input.pubTables {
+		table := tableNode(p.table)
+		table.mainstat = rates.rate(table.id, p.msgOffset, now)
+		offset := p.msgOffset
+		table.secondarystat = &offset
+		for _, action := range p.actions {
+			sub := subscriptionNode(p.table, action, p.subscriber)
+			addEdge(table.id, sub.id)
+		}
+	}
+	for _, w := range input.workers {
+		table := tableNode(w.table)
+		sub := subscriptionNode(w.table, w.action, w.subscriber)
+		sub.mainstat = rates.rate(sub.id, w.processedCount, now)
+		depth := w.queueDepth
+		sub.secondarystat = &depth
+		addEdge(table.id, sub.id)
+	}
+	for _, e := range input.engines {
+		engine := addNode(&topologyNode{id: "engine:" + e.name, title: e.name, subtitle: e.engineType, kind: "engine"})
+		engine.secondarystat = e.memoryUsed
+		for _, id := range order {
+			if n := nodes[id]; n.kind == "subscription" && n.title == e.name {
+				addEdge(n.id, engine.id)
+			}
+		}
+	}
+
+	nodeFrame := data.NewFrame("nodes",
+		data.NewField("id", nil, []string{}),
+		data.NewField("title", nil, []string{}),
+		data.NewField("subtitle", nil, []string{}),
+		data.NewField("mainstat", nil, []*float64{}).SetConfig(&data.FieldConfig{DisplayName: "msg/s", Unit: "short"}),
+		data.NewField("secondarystat", nil, []*float64{}).SetConfig(&data.FieldConfig{DisplayName: "queue depth / offset / memory"}),
+		data.NewField("detail__type", nil, []string{}).SetConfig(&data.FieldConfig{DisplayName: "Type"}),
+	)
+	for _, id := range order {
+		n := nodes[id]
+		nodeFrame.AppendRow(n.id, n.title, n.subtitle, n.mainstat, n.secondarystat, n.kind)
+	}
+
+	edgeIDs := make([]string, 0, len(edges))
+	for id := range edges {
+		edgeIDs = append(edgeIDs, id)
+	}
+	sort.Strings(edgeIDs)
+	edgeFrame := data.NewFrame("edges",
+		data.NewField("id", nil, []string{}),
+		data.NewField("source", nil, []string{}),
+		data.NewField("target", nil, []string{}),
+	)
+	for _, id := range edgeIDs {
+		edgeFrame.AppendRow(id, edges[id][0], edges[id][1])
+	}
+
+	for _, frame := range []*data.Frame{nodeFrame, edgeFrame} {
+		frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph}
+	}
+	return data.Frames{nodeFrame, edgeFrame}
+}
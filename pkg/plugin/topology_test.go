@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildTopologyFrames(t *testing.T) {
+	input := topologyInput{
+		pubTables: []pubTableRow{{table: "trades", subscriber: "localhost:8848", msgOffset: 100, actions: []string{"ohlc"}}},
+		workers:   []subWorkerRow{{table: "trades", action: "ohlc", subscriber: "localhost:8848", queueDepth: 3, processedCount: 90}},
+		engines:   []engineRow{{name: "ohlc", engineType: "TimeSeriesEngine"}},
+	}
+	rates := newRateTracker()
+	now := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	buildTopologyFrames(input, rates, now)
+
+	input.pubTables[0].msgOffset = 120
+	input.workers[0].processedCount = 110
+	frames := buildTopologyFrames(input, rates, now.Add(2*time.Second))
+
+	nodes, edges := frames[0], frames[1]
+	if nodes.Rows() != 3 {
+		t.Fatalf("expected table, subscription and engine nodes, got %d", nodes.Rows())
+	}
+	if edges.Rows() != 2 {
+		t.Fatalf("expected table->subscription->engine edges, got %d", edges.Rows())
+	}
+	mainstat, _ := nodes.FieldByName("mainstat")
+	if v := mainstat.At(1).(*float64); v == nil || *v != 10 {
+		t.Fatalf("unexpected subscription throughput %v", v)
+	}
+	secondary, _ := nodes.FieldByName("secondarystat")
+	if v := secondary.At(1).(*float64); v == nil || *v != 3 {
+		t.Fatalf("unexpected queue depth %v", v)
+	}
+}
@@ -11,10 +11,10 @@ import {
     DataSourcePlugin, DataSourceApi, MutableDataFrame, FieldType, LoadingState, CircularDataFrame,
     SelectableValue, type DataQueryRequest, type DataSourcePluginOptionsEditorProps,
     type DataSourceInstanceSettings, type DataQueryResponse, type QueryEditorProps,
-    type DataSourceJsonData, type MetricFindValue, type FieldDTO
+    type DataSourceJsonData, type MetricFindValue, type FieldDTO, type AdHocVariableFilter
 } from '@grafana/data'
-import { InlineField, Input, InlineSwitch, Button, Icon, Select } from '@grafana/ui'
-import { DataSourceOptions } from '../types'
+import { InlineField, Input, InlineSwitch, Button, Icon, Select, SecretInput } from '@grafana/ui'
+import { AnnotationMapping, DataSourceOptions, FunctionCall, LogsOptions, MonitorOptions, QueryBuilderModel, StreamingOptions, type UserMapping, type WritableTable } from '../types'
 
 type DataSourceConfig = DataSourceOptions;
 
@@ -496,17 +496,28 @@ console.log(t('DolphinDB Grafana 插件已加载'))
 export interface DdbDataQuery extends DataQuery {
     is_streaming: boolean
     queryText?: string
-    streaming?: {
-        table: string
-        action?: string
-    }
+    streaming?: StreamingOptions
+    arrayVector?: 'json' | 'expand'
+    annotation?: AnnotationMapping
+    format?: 'table' | 'logs'
+    logs?: LogsOptions
+    monitor?: MonitorOptions
+    adhocFilters?: AdHocVariableFilter[]
+    function?: FunctionCall
+    builder?: QueryBuilderModel
+    app?: string
+    cacheTTLSeconds?: number
+    incremental?: { enabled: boolean, overlapSeconds?: number }
+    chunks?: { count?: number, align?: 'day' | 'month' | 'year' }
+    downsample?: 'lttb' | 'minmax' | 'avg'
+    fill?: { mode: 'null' | 'previous' | 'zero' | 'linear' | 'value', value?: number }
 }
 
 
 export function ConfigEditor({
     options,
     onOptionsChange
-}: DataSourcePluginOptionsEditorProps<DataSourceConfig>) {
+}: DataSourcePluginOptionsEditorProps<DataSourceConfig, Record<string, string>>) {
     let { jsonData } = options
 
     jsonData.url ??= '127.0.0.1:8848'
@@ -516,6 +527,11 @@ export function ConfigEditor({
     jsonData.python ??= false
     jsonData.verbose ??= false
     jsonData.poolCapacity ??= '10'
+    jsonData.readOnly ??= false
+    jsonData.dashboardTimezone ??= false
+    jsonData.writableTables ??= []
+    jsonData.userMapping ??= []
+    jsonData.requireUserMapping ??= false
 
     function on_change(option: keyof DataSourceConfig, checked?: boolean) {
         return (event: React.FormEvent<HTMLInputElement>) => {
@@ -529,6 +545,49 @@ export function ConfigEditor({
         }
     }
 
+    function set_writable_table(index: number, table: WritableTable | null) {
+        const tables = [...options.jsonData.writableTables]
+        if (table)
+            tables[index] = table
+        else
+            tables.splice(index, 1)
+        onOptionsChange({
+            ...options,
+            jsonData: { ...options.jsonData, writableTables: tables }
+        })
+    }
+
+
+    function set_user_mapping(index: number, mapping: UserMapping | null) {
+        const mappings = [...options.jsonData.userMapping]
+        if (mapping)
+            mappings[index] = mapping
+        else
+            mappings.splice(index, 1)
+        onOptionsChange({
+            ...options,
+            jsonData: { ...options.jsonData, userMapping: mappings }
+        })
+    }
+
+    /** 映射的 DolphinDB 用户的密码保存在 secureJsonData 的 userPassword.<username> 中 */
+    function set_user_password(username: string, password: string | null) {
+        const key = `userPassword.${username}`
+        onOptionsChange({
+            ...options,
+            secureJsonFields: { ...options.secureJsonFields, [key]: password === null ? false : options.secureJsonFields?.[key] },
+            secureJsonData: { ...options.secureJsonData, [key]: password ?? '' }
+        })
+    }
+
+    const match_options: Array<SelectableValue<UserMapping['match']>> = [
+        { label: t('登录名'), value: 'login' },
+        { label: t('邮箱'), value: 'email' },
+        { label: t('角色'), value: 'role' },
+    ]
+
+    const mapped_usernames = [...new Set(options.jsonData.userMapping.map(({ username }) => username).filter(Boolean))]
+
 
     return <div className='gf-form-group'>
         <InlineField
@@ -592,6 +651,126 @@ export function ConfigEditor({
         </InlineField>
         <br />
 
+        <InlineField tooltip={t('拒绝执行修改数据的语句（如 delete、update、dropDatabase），包括变量查询和流数据，并禁止通过 Grafana Live 写入')} label={t('只读')} labelWidth={12}>
+            <InlineSwitch
+                value={options.jsonData.readOnly}
+                onChange={on_change('readOnly', true)}
+            />
+        </InlineField>
+        <br />
+
+        <InlineField tooltip={t('$timeFilter 和后端展开的时间宏按面板的时区展开，关闭时按浏览器的时区展开')} label={t('面板时区')} labelWidth={12}>
+            <InlineSwitch
+                value={options.jsonData.dashboardTimezone}
+                onChange={on_change('dashboardTimezone', true)}
+            />
+        </InlineField>
+        <br />
+
+        <InlineField tooltip={t('面板可以通过 Grafana Live 的 ds/<uid>/publish/<名称> 写入的表，角色为空时只允许 Admin 写入')} label={t('可写入的表')} labelWidth={12}>
+            <Button
+                variant='secondary'
+                icon='plus'
+                onClick={() => { set_writable_table(options.jsonData.writableTables.length, { name: '', table: '' }) }}
+            >{t('添加')}</Button>
+        </InlineField>
+        {options.jsonData.writableTables.map((table, index) =>
+            <div className='gf-form-inline' key={index}>
+                <InlineField label={t('名称')} labelWidth={12}>
+                    <Input
+                        value={table.name}
+                        onChange={event => { set_writable_table(index, { ...table, name: event.currentTarget.value }) }}
+                    />
+                </InlineField>
+                <InlineField label={t('数据库')}>
+                    <Input
+                        placeholder='dfs://StockDB'
+                        value={table.database ?? ''}
+                        onChange={event => { set_writable_table(index, { ...table, database: event.currentTarget.value || undefined }) }}
+                    />
+                </InlineField>
+                <InlineField label={t('表')}>
+                    <Input
+                        value={table.table}
+                        onChange={event => { set_writable_table(index, { ...table, table: event.currentTarget.value }) }}
+                    />
+                </InlineField>
+                <InlineField label={t('角色')} tooltip={t('允许写入的 Grafana 角色，用逗号分隔')}>
+                    <Input
+                        placeholder='Admin, Editor'
+                        value={(table.roles ?? []).join(', ')}
+                        onChange={event => {
+                            const roles = event.currentTarget.value.split(',').map(role => role.trim()).filter(Boolean)
+                            set_writable_table(index, { ...table, roles: roles.length ? roles : undefined })
+                        }}
+                    />
+                </InlineField>
+                <Button variant='secondary' icon='trash-alt' aria-label={t('删除')} onClick={() => { set_writable_table(index, null) }} />
+            </div>
+        )}
+        <br />
+
+        <InlineField
+            tooltip={t('按顺序把 Grafana 用户映射为 DolphinDB 用户，第一条匹配的映射生效。Grafana 不会把团队信息传给数据源插件，按组映射时请使用角色')}
+            label={t('用户映射')}
+            labelWidth={12}
+        >
+            <Button
+                variant='secondary'
+                icon='plus'
+                onClick={() => { set_user_mapping(options.jsonData.userMapping.length, { match: 'login', value: '', username: '' }) }}
+            >{t('添加')}</Button>
+        </InlineField>
+        {options.jsonData.userMapping.map((mapping, index) =>
+            <div className='gf-form-inline' key={index}>
+                <InlineField label={t('匹配')} labelWidth={12}>
+                    <Select
+                        options={match_options}
+                        value={mapping.match ?? 'login'}
+                        width={14}
+                        onChange={({ value }) => { set_user_mapping(index, { ...mapping, match: value }) }}
+                    />
+                </InlineField>
+                <InlineField label={t('值')}>
+                    <Input
+                        placeholder={mapping.match === 'role' ? 'Viewer' : mapping.match === 'email' ? 'alice@example.com' : 'alice'}
+                        value={mapping.value}
+                        onChange={event => { set_user_mapping(index, { ...mapping, value: event.currentTarget.value }) }}
+                    />
+                </InlineField>
+                <InlineField label={t('DolphinDB 用户名')}>
+                    <Input
+                        value={mapping.username}
+                        onChange={event => { set_user_mapping(index, { ...mapping, username: event.currentTarget.value }) }}
+                    />
+                </InlineField>
+                <Button variant='secondary' icon='trash-alt' aria-label={t('删除')} onClick={() => { set_user_mapping(index, null) }} />
+            </div>
+        )}
+        {mapped_usernames.map(username => {
+            const key = `userPassword.${username}`
+            return <div key={key}>
+                <InlineField label={t('{{username}} 的密码', { username })} labelWidth={24}>
+                    <SecretInput
+                        isConfigured={Boolean(options.secureJsonFields?.[key])}
+                        value={options.secureJsonData?.[key] ?? ''}
+                        width={30}
+                        onReset={() => { set_user_password(username, null) }}
+                        onChange={event => { set_user_password(username, event.currentTarget.value) }}
+                    />
+                </InlineField>
+            </div>
+        })}
+        {options.jsonData.userMapping.length > 0 &&
+            <InlineField tooltip={t('没有匹配的映射时拒绝查询，关闭时使用上面配置的用户')} label={t('要求映射')} labelWidth={12}>
+                <InlineSwitch
+                    value={options.jsonData.requireUserMapping}
+                    onChange={on_change('requireUserMapping', true)}
+                />
+            </InlineField>
+        }
+        <br />
+
         {/*
         Go API 暂时不支持 Python Parser Session，先不做
 
@@ -1,8 +1,8 @@
-import { DataSourceInstanceSettings, CoreApp, DataQueryResponse, MetricFindValue, DataQueryRequest, LiveChannelScope, LegacyMetricFindQueryOptions } from '@grafana/data';
+import { AnnotationQuery, DataSourceInstanceSettings, CoreApp, DataQueryResponse, MetricFindValue, DataQueryRequest, LiveChannelScope, LegacyMetricFindQueryOptions, StreamingFrameAction, DataSourceWithSupplementaryQueriesSupport, SupplementaryQueryType, SupplementaryQueryOptions, LogRowModel, LogRowContextOptions, getDefaultTimeRange, DataSourceGetTagValuesOptions, ScopedVars } from '@grafana/data';
 import { DataSourceWithBackend, getBackendSrv, getGrafanaLiveSrv, getTemplateSrv } from '@grafana/runtime';
 
-import { DdbDataQuery, DataSourceOptions, DEFAULT_QUERY, IQueryRespData } from './types';
-import { Observable } from 'rxjs';
+import { DdbDataQuery, DataSourceOptions, FunctionCall, DEFAULT_QUERY, IQueryRespData } from './types';
+import { Observable, lastValueFrom } from 'rxjs';
 
 import dayjs from 'dayjs';
 import utc from 'dayjs/plugin/utc';
@@ -28,32 +28,46 @@ type GrafanaTimezone = 'browser' | 'utc' | string
 //   )
 // ]
 
-export class DataSource extends DataSourceWithBackend<DdbDataQuery, DataSourceOptions> {
+export class DataSource extends DataSourceWithBackend<DdbDataQuery, DataSourceOptions> implements DataSourceWithSupplementaryQueriesSupport<DdbDataQuery> {
+  /** 时间范围按面板时区展开，关闭时和之前一样按浏览器的时区展开 */
+  dashboardTimezone: boolean
+
   constructor(instanceSettings: DataSourceInstanceSettings<DataSourceOptions>) {
     console.log(instanceSettings)
     super(instanceSettings);
+    this.dashboardTimezone = Boolean(instanceSettings.jsonData.dashboardTimezone)
+    // 注释查询由后端执行，通过 queryType 区分，后端把结果转换为注释需要的列
+    this.annotations = {
+      prepareQuery: (anno: AnnotationQuery<DdbDataQuery>) =>
+        anno.target ? { ...anno.target, refId: anno.target.refId ?? 'Anno', queryType: 'annotation' } : undefined
+    }
   }
 
   query(request: DataQueryRequest<DdbDataQuery>): Observable<DataQueryResponse> {
     const { range: { from, to }, scopedVars } = request
     const { timezone } = request
+    // 时间范围按浏览器时区（开启 dashboardTimezone 时为面板时区）的墙上时间展开，后端展开的宏也使用这个时区
+    const tz = resolve_timezone(this.dashboardTimezone ? timezone : 'browser')
+
+    // 临时过滤条件由后端展开到 $__adhocFilters 中
+    const adhocFilters = getTemplateSrv().getAdhocFilters(this.name)
 
     // 非流
     const commonQueriesTargets = request.targets.filter(query => !query.is_streaming).map(query => {
       const code = query.queryText ?? '';
       const tplsrv = getTemplateSrv();
-      (from as any)._isUTC = false;
-      (to as any)._isUTC = false;
+      // 增量查询和拆分查询的时间范围由后端按新的时间窗口展开
+      const backendTimeFilter = (query.incremental?.enabled || (query.chunks?.count ?? 0) > 1 || Boolean(query.chunks?.align)) && !query.queryType
       const code_ = tplsrv
         .replace(
           code //@ts-ignore
             .replaceAll(
               /\$(__)?timeFilter\b/g,
-              () =>
+              (macro: string) => backendTimeFilter ? macro :
                 'pair(' +
-                from.format('YYYY.MM.DD HH:mm:ss.SSS') +
+                format_ddb_time(from, tz) +
                 ', ' +
-                to.format('YYYY.MM.DD HH:mm:ss.SSS') +
+                format_ddb_time(to, tz) +
                 ')'
             ).replaceAll(
               /\$__interval\b/g,
@@ -64,7 +78,17 @@ export class DataSource extends DataSourceWithBackend<DdbDataQuery, DataSourceOp
           var_formatter
         )
       return {
-        ...query, queryText: code_
+        ...query, queryText: code_, adhocFilters, app: request.app, timezone: tz,
+        function: query.function && replace_function_args(query.function, scopedVars),
+        // 构建器过滤条件中的模板变量在这里展开，值由后端转换为字面量
+        builder: query.builder && {
+          ...query.builder,
+          filters: query.builder.filters?.map(f => ({
+            ...f,
+            value: tplsrv.replace(f.value, scopedVars),
+            values: f.values?.map(v => tplsrv.replace(v, scopedVars))
+          }))
+        }
       }
     });
     const streamingQueries = request.targets.filter(query => query.is_streaming);
@@ -104,15 +128,28 @@ export class DataSource extends DataSourceWithBackend<DdbDataQuery, DataSourceOp
        * 处理流数据
        */
       const observables = streamingQueries.map((query) => {
+        let path = `ws/streaming-${query.refId}-${(query.streaming?.tables?.length ? query.streaming.tables : [query.streaming?.table ?? '']).join('_')}`.replace(/[^\w\-=/.]/g, '_')
+        // 回放模式没有指定起止时间时使用面板的时间范围，时间范围不同的回放要使用不同的 channel
+        if (query.streaming?.mode === 'replay' && query.streaming.replay) {
+          const replay = {
+            ...query.streaming.replay,
+            from: query.streaming.replay.from || format_ddb_time(from, tz),
+            to: query.streaming.replay.to || format_ddb_time(to, tz),
+          }
+          query = { ...query, streaming: { ...query.streaming, replay } }
+          path = `ws/replay-${query.refId}-${replay.table}-${replay.from}-${replay.to}`.replace(/[^\w\-=/.]/g, '_')
+        }
         return getGrafanaLiveSrv().getDataStream({
           addr: {
             scope: LiveChannelScope.DataSource,
             namespace: this.uid,
-            path: `ws/streaming-${query.refId}-${query.streaming?.table ?? ''}`,
+            path,
             data: {
               ...query,
             },
           },
+          // latest 模式下后端每次推送的是全量快照，需要替换而不是追加
+          buffer: query.streaming?.mode === 'latest' ? { action: StreamingFrameAction.Replace } : undefined,
         });
       });
 
@@ -151,6 +188,71 @@ export class DataSource extends DataSourceWithBackend<DdbDataQuery, DataSourceOp
     return DEFAULT_QUERY;
   }
 
+  /** 临时过滤的标签键为数据源配置的表的列名 */
+  async getTagKeys(): Promise<MetricFindValue[]> {
+    return this.postResource('tagKeys', { })
+  }
+
+  /** 临时过滤的标签值为这一列在面板时间范围内的不同取值 */
+  async getTagValues(options: DataSourceGetTagValuesOptions): Promise<MetricFindValue[]> {
+    return this.postResource('tagValues', {
+      key: options.key,
+      from: options.timeRange?.from.valueOf(),
+      to: options.timeRange?.to.valueOf(),
+      // 标签值的请求中没有面板时区，和没有设置时区的面板一样使用浏览器的时区
+      timezone: resolve_timezone('browser'),
+    })
+  }
+
+  /** 清空后端的查询结果缓存，返回清除的结果数 */
+  async invalidateCache(): Promise<number> {
+    const { invalidated } = await this.postResource('cache/invalidate', { })
+    return invalidated
+  }
+
+  /** logs 格式的查询支持 Explore 的日志量直方图，由后端根据查询自动生成聚合查询 */
+  getSupportedSupplementaryQueryTypes(): SupplementaryQueryType[] {
+    return [SupplementaryQueryType.LogsVolume]
+  }
+
+  getSupplementaryQuery(options: SupplementaryQueryOptions, query: DdbDataQuery): DdbDataQuery | undefined {
+    if (options.type !== SupplementaryQueryType.LogsVolume || query.format !== 'logs' || query.is_streaming)
+      return undefined
+    return { ...query, refId: `log-volume-${query.refId}`, queryType: 'logsVolume' }
+  }
+
+  getDataProvider(type: SupplementaryQueryType, request: DataQueryRequest<DdbDataQuery>): Observable<DataQueryResponse> | undefined {
+    const targets = request.targets
+      .map(query => this.getSupplementaryQuery({ type }, query))
+      .filter((query): query is DdbDataQuery => !!query)
+    if (!targets.length)
+      return undefined
+    return this.query({ ...request, targets })
+  }
+
+  /** 日志上下文，查询日志表中这一行之前或之后的若干行 */
+  getLogRowContext = async (row: LogRowModel, options?: LogRowContextOptions, query?: DdbDataQuery): Promise<DataQueryResponse> => {
+    if (!query)
+      return { data: [ ] }
+    const target: DdbDataQuery = {
+      ...query,
+      refId: `log-context-${query.refId}`,
+      queryType: 'logsContext',
+      logs: { ...query.logs, context: { time: row.timeEpochMs, direction: options?.direction, limit: options?.limit } }
+    }
+    return lastValueFrom(this.query({
+      targets: [target],
+      range: getDefaultTimeRange(),
+      scopedVars: { },
+      interval: '',
+      intervalMs: 0,
+      timezone: 'browser',
+      app: CoreApp.Explore,
+      requestId: target.refId,
+      startTime: Date.now(),
+    }))
+  }
+
   override async metricFindQuery(query: string, options: LegacyMetricFindQueryOptions): Promise<MetricFindValue[]> {
     console.log('metricFindQuery:', { query, options })
     const queryText = getTemplateSrv().replace(query, {}, var_formatter)
@@ -215,6 +317,35 @@ function var_formatter(value: string | string[], variable: any, default_formatte
   return JSON.stringify(value)
 }
 
+/** 展开函数调用参数中的模板变量，vector 参数中的多值变量展开为多个元素，不拼接到脚本中 */
+function replace_function_args(fn: FunctionCall, scopedVars: ScopedVars): FunctionCall {
+  const tplsrv = getTemplateSrv()
+  return {
+    ...fn,
+    name: tplsrv.replace(fn.name, scopedVars),
+    args: fn.args?.map(arg => {
+      const { value } = arg
+      if (arg.form === 'vector')
+        return {
+          ...arg,
+          value: (Array.isArray(value) ? value : [value]).flatMap(v => {
+            if (typeof v !== 'string')
+              return [v]
+            // 变量展开为 JSON，不含变量的普通字符串原样作为一个元素
+            const replaced = tplsrv.replace(v, scopedVars, 'json')
+            try {
+              const parsed = JSON.parse(replaced)
+              return Array.isArray(parsed) ? parsed : [parsed]
+            } catch {
+              return [replaced]
+            }
+          })
+        }
+      return typeof value === 'string' ? { ...arg, value: tplsrv.replace(value, scopedVars) } : arg
+    })
+  }
+}
+
 function convertQueryRespTime(data: IQueryRespData, targetTimezone: GrafanaTimezone) {
   return data.map(item => {
     return {
@@ -227,6 +358,20 @@ function convertQueryRespTime(data: IQueryRespData, targetTimezone: GrafanaTimez
   })
 }
 
+/** 面板时区对应的 IANA 时区名，browser 为浏览器所在的时区 */
+function resolve_timezone(tz: GrafanaTimezone): string {
+  if (!tz || tz === 'browser' || tz === 'default')
+    return dayjs.tz.guess()
+  if (tz === 'utc')
+    return 'UTC'
+  return tz
+}
+
+/** 把时刻格式化为 tz 中的墙上时间，作为 DolphinDB 的时间字面量 */
+function format_ddb_time(time: { valueOf(): number }, tz: string) {
+  return dayjs(time.valueOf()).tz(tz).format('YYYY.MM.DD HH:mm:ss.SSS')
+}
+
 function timestampConvert(timestamp: number, targetTimezone: GrafanaTimezone) {
   let target = targetTimezone
   if(targetTimezone === 'browser'){
@@ -1,13 +1,152 @@
-import { DataSourceJsonData } from '@grafana/data';
+import { AdHocVariableFilter, DataSourceJsonData } from '@grafana/data';
 import { DataQuery } from '@grafana/schema';
 
 export interface DdbDataQuery extends DataQuery {
   is_streaming: boolean
   queryText?: string
-  streaming?: {
-    table: string
-    action?: string
-  }
+  streaming?: StreamingOptions
+  /** 数组向量列的展示方式，json: 渲染为 JSON 数组（默认）；expand: 按下标展开为多列 */
+  arrayVector?: 'json' | 'expand'
+  /** 注释查询的列映射 */
+  annotation?: AnnotationMapping
+  /** 结果的格式，table（默认）或 logs */
+  format?: 'table' | 'logs'
+  /** logs 格式的列配置，为空时自动识别 */
+  logs?: LogsOptions
+  /** 集群监控查询（queryType 为 clusterPerf、streamingStat、recentJobs、streamEngineStat）的参数 */
+  monitor?: MonitorOptions
+  /** 面板上生效的临时过滤条件，由 datasource.ts 填写，后端展开到 $__adhocFilters 中 */
+  adhocFilters?: AdHocVariableFilter[]
+  /** 函数调用查询（queryType 为 function）的函数名和参数 */
+  function?: FunctionCall
+  /** 可视化查询构建器（queryType 为 builder）的结构化查询，由后端编译为 SQL */
+  builder?: QueryBuilderModel
+  /** 发起查询的应用（CoreApp），由 datasource.ts 填写，决定后端的调度优先级 */
+  app?: string
+  /** 这个查询的结果缓存时间（秒），为空时使用数据源的配置，为 0 时不缓存 */
+  cacheTTLSeconds?: number
+  /** 增量查询，只查询上次结果之后的新数据，和上次的结果合并 */
+  incremental?: { enabled: boolean, overlapSeconds?: number }
+  /** 把时间范围拆分为多个块并行查询，按块数平均拆分或者按 day、month、year 的边界拆分 */
+  chunks?: { count?: number, align?: 'day' | 'month' | 'year' }
+  /** 时间序列结果超过 maxDataPoints 时的降采样算法，为空时不降采样 */
+  downsample?: 'lttb' | 'minmax' | 'avg'
+  /** 按查询的间隔对齐时间列，补齐整个时间范围内缺失的时间点，mode 为 value 时填充 value */
+  fill?: { mode: 'null' | 'previous' | 'zero' | 'linear' | 'value', value?: number }
+  /** 展开时间范围的时区（浏览器或面板的时区）的 IANA 名称，由 datasource.ts 填写，后端按这个时区展开宏和计算时间边界 */
+  timezone?: string
+}
+
+export interface QueryBuilderModel {
+  /** 分布式数据库路径，例如 dfs://StockDB，为空时 table 为内存表或共享表 */
+  database?: string
+  table: string
+  timeColumn?: string
+  /** 直接选择的列，有聚合时必须出现在 groupBy 中 */
+  columns?: string[]
+  aggregations?: Array<{ func: string, column: string, alias?: string }>
+  groupBy?: string[]
+  /** 过滤条件，运算符和临时过滤条件相同 */
+  filters?: AdHocVariableFilter[]
+  /** 按时间列分桶的间隔，例如 1m，auto 为面板的间隔 */
+  interval?: string
+  orderBy?: Array<{ column: string, direction?: 'asc' | 'desc' }>
+  limit?: number
+}
+
+export interface FunctionCall {
+  /** 服务端函数或函数视图的名称 */
+  name: string
+  args?: FunctionArg[]
+}
+
+export interface FunctionArg {
+  /** 参数值的来源，value（默认）为 value 中的值，其他的由查询的时间范围和间隔得到 */
+  source?: 'value' | 'timeFrom' | 'timeTo' | 'interval' | 'intervalMs'
+  /** DolphinDB 的类型名，例如 INT、SYMBOL、TIMESTAMP、DECIMAL64(2) */
+  type?: string
+  /** scalar（默认）或 vector，vector 时 value 为数组，多值模板变量展开为多个元素 */
+  form?: 'scalar' | 'vector'
+  value?: string | number | boolean | Array<string | number | boolean>
+}
+
+export interface MonitorOptions {
+  /** streamingStat 为 getStreamingStat() 中的表，例如 subWorkers；streamEngineStat 为引擎类型，例如 TimeSeriesEngine */
+  section?: string
+  /** recentJobs 返回的作业数 */
+  limit?: number
+}
+
+export interface LogsOptions {
+  /** 日志所在的表，日志量直方图和日志上下文需要 */
+  database?: string
+  table?: string
+  timeColumn?: string
+  bodyColumn?: string
+  levelColumn?: string
+  labelColumns?: string[]
+  /** 日志上下文的参数，由 getLogRowContext 设置 */
+  context?: { time: number, direction?: string, limit?: number }
+}
+
+/** 注释字段对应的列名，为空时使用同名的列 */
+export interface AnnotationMapping {
+  time?: string
+  timeEnd?: string
+  title?: string
+  text?: string
+  tags?: string
+}
+
+export interface StreamingOptions {
+  table: string
+  /** 同时订阅多张流数据表，不为空时忽略 table */
+  tables?: string[]
+  /** 订阅多张表时按时间列合并为一个 frame，为空时每张表推送各自的 frame */
+  merge?: StreamingMerge
+  action?: string
+  /** append: 追加每条消息（默认）；latest: 按键列保留最新值，定时推送全量快照；window: 插件内窗口聚合；replay: 回放历史数据 */
+  mode?: 'append' | 'latest' | 'window' | 'replay'
+  keyColumns?: string[]
+  snapshotIntervalMs?: number
+  window?: StreamingWindow
+  replay?: StreamingReplay
+}
+
+export interface StreamingMerge {
+  /** 各表共同的时间列，第一张表为主表，每行和其他表中时间不晚于它的最近一行合并 */
+  timeColumn: string
+  /** 允许的最大时间差，单位毫秒，0 表示不限制 */
+  toleranceMs?: number
+  /** 主表的行等待其他表数据的最长时间，单位毫秒 */
+  maxWaitMs?: number
+}
+
+export interface StreamingReplay {
+  /** 分布式数据库路径，为空时 table 为内存表或共享表 */
+  database?: string
+  table: string
+  timeColumn: string
+  /** 起止时间，为空时使用面板的时间范围 */
+  from?: string
+  to?: string
+  /** 回放速度，1 为原速 */
+  speed?: number
+  batchMs?: number
+}
+
+export interface StreamingWindow {
+  type?: 'tumbling' | 'sliding'
+  sizeMs: number
+  slideMs?: number
+  /** 事件时间列，为空时按数据到达时间聚合 */
+  timeColumn?: string
+  groupBy?: string
+  aggregations: Array<{
+    column?: string
+    func: 'count' | 'sum' | 'avg' | 'min' | 'max' | 'first' | 'last'
+    alias?: string
+  }>
 }
 
 export const DEFAULT_QUERY: Partial<DdbDataQuery> = {
@@ -34,6 +173,53 @@ export interface DataSourceOptions extends DataSourceJsonData {
   python?: boolean
   verbose?: boolean
   poolCapacity?: string
+  writableTables?: WritableTable[]
+  /** 临时过滤的标签键（列名）和标签值来自这张表 */
+  adhocTable?: AdhocTable
+  /** 只读模式，拒绝执行修改数据的 SQL 语句和危险的函数，包括变量查询，并禁止通过 Grafana Live 写入 */
+  readOnly?: boolean
+  /** 只读模式下在默认列表之外额外禁止的函数 */
+  dangerousFunctions?: string[]
+  /** $timeFilter 和后端展开的时间宏使用面板的时区，默认使用浏览器的时区 */
+  dashboardTimezone?: boolean
+  /** 把 Grafana 用户映射为 DolphinDB 用户，按顺序匹配，密码保存在 secureJsonData 的 userPassword.<username> 中 */
+  userMapping?: UserMapping[]
+  /** 没有匹配的映射时拒绝查询，否则使用上面配置的用户 */
+  requireUserMapping?: boolean
+  /** 数据源同时执行的查询数，超过时排队，为 0 或为空时不限制 */
+  maxConcurrentQueries?: number
+  /** 查询排队的超时时间（秒），默认 30 */
+  queueTimeoutSeconds?: number
+  /** 查询结果的缓存时间（秒），为 0 或为空时不缓存 */
+  cacheTTLSeconds?: number
+  /** 缓存最多保存的查询结果数，默认 1000 */
+  cacheMaxEntries?: number
+}
+
+export interface UserMapping {
+  /** 按 Grafana 的登录名（默认）、邮箱或者角色匹配。Grafana 不会把团队信息传给数据源插件，所以不支持按团队匹配 */
+  match?: 'login' | 'email' | 'role'
+  value: string
+  /** DolphinDB 用户名 */
+  username: string
+}
+
+export interface AdhocTable {
+  /** 分布式数据库路径，为空时 table 为内存表或共享表 */
+  database?: string
+  table: string
+  /** 查询标签值时按面板的时间范围过滤 */
+  timeColumn?: string
+}
+
+/** 允许通过 Grafana Live 的 ds/<uid>/publish/<name> channel 写入的表 */
+export interface WritableTable {
+  name: string
+  /** 分布式数据库路径，为空时 table 为共享的流表或内存表 */
+  database?: string
+  table: string
+  /** 允许写入的 Grafana 角色，默认只有 Admin */
+  roles?: string[]
 }
 
 /**